@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"tinyap.org/ping/pump"
+
+	"github.com/google/subcommands"
+	"golang.org/x/net/context"
+)
+
+type bolusCmd struct {
+	pump      *pump.Pump
+	extended  time.Duration
+	immediate string
+	yes       bool
+}
+
+func (*bolusCmd) Name() string     { return "bolus" }
+func (*bolusCmd) Synopsis() string { return "Deliver a bolus" }
+func (*bolusCmd) Usage() string {
+	return `bolus [-yes] [-extended duration [-immediate amount]] <amount>:
+  Deliver a bolus, optionally extended over a duration. A dual-wave
+  bolus delivers the immediate amount now, and the rest of the total
+  extended. The bolus is checked against the configured limits and
+  must be confirmed. Interrupting delivery cancels an extended bolus;
+  a normal bolus cannot be cancelled once it is sent.
+`
+}
+
+func (b *bolusCmd) SetFlags(f *flag.FlagSet) {
+	f.DurationVar(&b.extended, "extended", 0, "Extend the bolus over this duration, in multiples of 6 minutes.")
+	f.StringVar(&b.immediate, "immediate", "", "Deliver this much of an extended bolus immediately.")
+	f.BoolVar(&b.yes, "yes", false, "Deliver without asking for confirmation.")
+}
+
+func (b *bolusCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if len(f.Args()) != 1 {
+		return subcommands.ExitUsageError
+	}
+
+	amount, err := pump.ParseAmount(f.Args()[0])
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	var immediate pump.Amount
+	if b.immediate != "" {
+		immediate, err = pump.ParseAmount(b.immediate)
+		if err != nil || b.extended == 0 || immediate >= amount {
+			log.Printf("-immediate must be less than the total of an extended bolus")
+			return subcommands.ExitUsageError
+		}
+	}
+
+	warnNoLimits(b.pump)
+
+	stat, err := b.pump.Stat()
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+
+	if stat.LastBolusTime.IsZero() {
+		fmt.Printf("IOB %s, no last bolus\n", stat.IOB)
+	} else {
+		fmt.Printf("IOB %s, last bolus %s at %s (%s ago)\n",
+			stat.IOB, stat.LastBolus, stat.LastBolusTime.Format(time.Stamp),
+			stat.Now.Sub(stat.LastBolusTime))
+	}
+	if stat.ComboActive {
+		fmt.Printf("combo active: %s/%s until %s\n",
+			stat.ComboDelivered, stat.ComboTotal, stat.ComboEnd.Format(time.Kitchen))
+	}
+
+	limits := b.pump.Limits()
+	if immediate != 0 {
+		err = limits.CheckDual(stat, immediate, amount-immediate, b.extended)
+	} else {
+		err = limits.Check(stat, amount, b.extended)
+	}
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+
+	var what string
+	switch {
+	case immediate != 0:
+		what = fmt.Sprintf("%s now and %s over %s", immediate, amount-immediate, b.extended)
+	case b.extended != 0:
+		what = fmt.Sprintf("%s over %s", amount, b.extended)
+	default:
+		what = amount.String()
+	}
+	if !b.yes && !confirm(fmt.Sprintf("deliver %s?", what)) {
+		log.Print("bolus not delivered")
+		return subcommands.ExitFailure
+	}
+
+	if immediate != 0 {
+		// DualBolus reconciles both portions itself.
+		first, second, err := b.pump.DualBolus(immediate, amount-immediate, b.extended)
+		if err != nil {
+			log.Print(err)
+		}
+		log.Printf("immediate %s: %s", immediate, first)
+		log.Printf("extended %s over %s: %s", amount-immediate, b.extended, second)
+
+		switch {
+		case first == pump.OutcomeUnknown || second == pump.OutcomeUnknown:
+			log.Printf("cannot tell whether the bolus was delivered; check the pump before retrying")
+		case err == nil:
+			return subcommands.ExitSuccess
+		}
+		return subcommands.ExitFailure
+	}
+
+	// The pump's state may have changed while we waited for
+	// confirmation, so record the intent against a fresh reading.
+	in, err := b.pump.NewBolusIntent(amount, b.extended)
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	if err := in.Check(); err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+
+	// Cancel an extended bolus on the first interrupt during
+	// delivery; a second one exits. A normal bolus is delivered
+	// regardless, so we keep watching it. Once delivery is no longer
+	// watched, an interrupt exits.
+	var cancel chan struct{}
+	if b.extended != 0 {
+		cancel = make(chan struct{})
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	watched, disarmed := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(disarmed)
+		select {
+		case <-sig:
+		case <-watched:
+			return
+		}
+		signal.Stop(sig)
+		if cancel == nil {
+			log.Print("a normal bolus cannot be cancelled; delivery continues")
+			return
+		}
+		log.Print("cancelling bolus")
+		close(cancel)
+	}()
+
+	progress := func(e pump.BolusEvent) {
+		log.Print(e)
+	}
+
+	err = b.pump.BolusProgress(in.Bolus, in.Duration, progress, cancel)
+	signal.Stop(sig)
+	close(watched)
+	<-disarmed
+	if cancel != nil && err != pump.ErrBolusCancelled {
+		select {
+		case <-cancel:
+			log.Print("interrupted too late; the bolus was not cancelled")
+		default:
+		}
+	}
+	if err == pump.ErrBolusCancelled {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	if b.pump.Dryrun() {
+		return subcommands.ExitSuccess
+	}
+
+	// Verify the bolus against the pump's own record, whether or
+	// not delivery reported an error.
+	outcome, rerr := b.pump.Reconcile(in)
+	if rerr != nil {
+		log.Printf("cannot verify bolus: %s", rerr)
+		outcome = pump.OutcomeUnknown
+	}
+	if err != nil {
+		log.Print(err)
+	}
+
+	switch outcome {
+	case pump.OutcomeDelivered:
+		log.Printf("pump reports %s delivered", what)
+		return subcommands.ExitSuccess
+	case pump.OutcomeNotDelivered:
+		log.Printf("pump reports bolus not delivered")
+	default:
+		log.Printf("cannot tell whether the bolus was delivered; check the pump before retrying")
+	}
+	return subcommands.ExitFailure
+}
+
+// confirm asks the user a yes or no question on the terminal.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
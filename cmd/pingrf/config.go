@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"tinyap.org/ping/pump"
+)
+
+var configFlag = flag.String("config", os.ExpandEnv("$TAP/pingrf.json"), "The configuration file, including safety limits.")
+
+// The pingrf configuration file. Amounts and rates are given either
+// as strings with a unit, such as "2U" or "1.5U/hr", or as numbers of
+// milliunits (per hour).
+type config struct {
+	Limits pump.Limits `json:"limits"`
+}
+
+// loadConfig reads the configuration at path. A missing file yields
+// the zero configuration, unless the file is required.
+func loadConfig(path string, required bool) (*config, error) {
+	c := new(config)
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// flagSet tells whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// warnNoLimits logs a warning if the pump enforces no safety limits.
+// It is called before each delivery.
+func warnNoLimits(p *pump.Pump) {
+	if p.Limits() == (pump.Limits{}) {
+		log.Printf("warning: no safety limits are configured (see -config %s)", *configFlag)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// writeJSON writes v to w as a single line of JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeCSV writes v, a struct, to w as a CSV header and record. Columns
+// are named and encoded as in the struct's JSON encoding, except that
+// zero times, such as the bounds of a temp that is not set, are empty.
+func writeCSV(w io.Writer, v interface{}) error {
+	header, record, err := csvRecord(v)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.Write(record)
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvRecord(v interface{}) (header, record []string, err error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, nil, errors.New(fmt.Sprintf("cannot write %T as CSV", v))
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		f := rv.Field(i).Interface()
+		if t, ok := f.(time.Time); ok && t.IsZero() {
+			header = append(header, name)
+			record = append(record, "")
+			continue
+		}
+
+		b, err := json.Marshal(f)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Strings are written unquoted.
+		val := string(b)
+		var s string
+		if json.Unmarshal(b, &s) == nil {
+			val = s
+		}
+
+		header = append(header, name)
+		record = append(record, val)
+	}
+
+	return header, record, nil
+}
+
+// writeTemplate writes v to w as formatted by the Go template text,
+// followed by a newline.
+func writeTemplate(w io.Writer, text string, v interface{}) error {
+	t, err := template.New("format").Parse(text)
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(w, v); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"tinyap.org/ping/pump"
+)
+
+func TestWriteCSV(t *testing.T) {
+	now := time.Date(2016, 6, 5, 18, 30, 0, 0, time.UTC)
+	stat := &pump.Stat{
+		Now:           now,
+		Drift:         time.Minute,
+		Basal:         800 * pump.MilliunitsPerHour,
+		Reservoir:     120 * pump.Unit,
+		IOB:           1250 * pump.Milliunit,
+		LastBolus:     2 * pump.Unit,
+		LastBolusTime: now.Add(-time.Hour),
+		DailyBasal:    9 * pump.Unit,
+		DailyBolus:    6 * pump.Unit,
+	}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, stat); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "now,drift_ns,drift_warn,basal,reservoir,iob,last_bolus,last_bolus_time," +
+		"temp,temp_begin,temp_end,combo_active,combo_begin,combo_end,combo_delivered,combo_total," +
+		"daily_basal,daily_bolus,suspended,warn\n" +
+		"2016-06-05T18:30:00Z,60000000000,false,800,120000,1250,2000,2016-06-05T17:30:00Z," +
+		"0,,,false,,,0,0,9000,6000,false,false\n"
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -16,7 +15,13 @@ import (
 	"golang.org/x/net/context"
 )
 
-var radioFlag = flag.String("radio", "usb:/dev/cu.usbmodem000001", "The radio with which to talk to the pump.")
+var (
+	radioFlag  = flag.String("radio", "usb:/dev/cu.usbmodem000001", "The radio with which to talk to the pump.")
+	dryrunFlag = flag.Bool("dryrun", false, "Log, but do not transmit, calls that affect insulin delivery.")
+	tzFlag     = flag.String("tz", "", "The time zone of the pump's clock, if not the host's.")
+	driftFlag  = flag.Duration("drift", 5*time.Minute, "Warn when the pump's clock drifts more than this from the host's.")
+	clearFlag  = flag.Bool("clearwarn", false, "Let setrate and serve clear an active warning on the pump, rather than refuse.")
+)
 
 type printCmd struct {
 	capitalize bool
@@ -30,25 +35,67 @@ func (*printCmd) Usage() string {
 `
 }
 
-type statCmd struct{ pump *pump.Pump }
+type statCmd struct {
+	pump     *pump.Pump
+	format   string
+	template string
+}
+
+func (*statCmd) Name() string     { return "stat" }
+func (*statCmd) Synopsis() string { return "Queries and prints pump statistics." }
+func (*statCmd) Usage() string {
+	return `stat [-format table|json|csv] [-template text]:
+  Query and print pump statistics. JSON and CSV times are RFC 3339,
+  amounts are in milliunits, and rates in milliunits per hour. Times
+  that do not apply are 0001-01-01T00:00:00Z in JSON, and empty in
+  CSV. A Go template is executed on pump.Stat.
+`
+}
 
-func (*statCmd) Name() string             { return "stat" }
-func (*statCmd) Synopsis() string         { return "Queries and prints pump statistics." }
-func (*statCmd) Usage() string            { return "stat\n" }
-func (*statCmd) SetFlags(f *flag.FlagSet) {}
+func (s *statCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&s.format, "format", "table", "The output format: table, json, or csv.")
+	f.StringVar(&s.template, "template", "", "Format the output with this Go template.")
+}
 
 func (s *statCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	switch s.format {
+	case "table", "json", "csv":
+	default:
+		log.Printf("invalid format %q", s.format)
+		return subcommands.ExitUsageError
+	}
+
 	stat, err := s.pump.Stat()
 	if err != nil {
 		log.Print(err)
 		return subcommands.ExitFailure
 	}
 
+	switch {
+	case s.template != "":
+		err = writeTemplate(os.Stdout, s.template, stat)
+	case s.format == "json":
+		err = writeJSON(os.Stdout, stat)
+	case s.format == "csv":
+		err = writeCSV(os.Stdout, stat)
+	default:
+		printStat(stat)
+	}
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+func printStat(stat *pump.Stat) {
 	tw := new(tabwriter.Writer)
 	tw.Init(os.Stdout, 0, 4, 2, ' ', 0)
 
 	fmt.Fprintf(tw, "time\t%s\n", stat.Now.Format(time.Stamp))
-	fmt.Fprintf(tw, "reservoir\t%s\n", stat.Reservoir)
+	fmt.Fprintf(tw, "drift\t%s\n", stat.Drift)
+	fmt.Fprintf(tw, "reservoir\t%s (%s left)\n", stat.Reservoir, stat.ReservoirLeft())
 	fmt.Fprintf(tw, "basal\t%s\n", stat.Basal)
 	fmt.Fprintf(tw, "last bolus\t%s\n", stat.LastBolus)
 	fmt.Fprintf(tw, "IOB\t%s\n", stat.IOB)
@@ -66,12 +113,34 @@ func (s *statCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{})
 			stat.ComboBegin.Format(time.Kitchen),
 			stat.ComboEnd.Format(time.Kitchen))
 	}
+	if stat.Suspended {
+		fmt.Fprintf(tw, "SUSPENDED\n")
+	}
 	if stat.Warn {
 		fmt.Fprintf(tw, "WARNING ACTIVE\n")
 	}
+	if stat.DriftWarn {
+		fmt.Fprintf(tw, "CLOCK DRIFT\n")
+	}
 
 	tw.Flush()
+}
+
+type status1Cmd struct{ pump *pump.Pump }
+
+func (*status1Cmd) Name() string             { return "status1" }
+func (*status1Cmd) Synopsis() string         { return "Dump the raw Status1 message." }
+func (*status1Cmd) Usage() string            { return "status1\n" }
+func (*status1Cmd) SetFlags(f *flag.FlagSet) {}
+
+func (s *status1Cmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	status1, err := s.pump.Status1()
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
 
+	fmt.Print(status1.Dump())
 	return subcommands.ExitSuccess
 }
 
@@ -102,13 +171,13 @@ func (s *setRateCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		return subcommands.ExitUsageError
 	}
 
-	in, err := strconv.ParseFloat(f.Args()[0], 64)
+	rate, err := pump.ParseRate(f.Args()[0])
 	if err != nil {
 		log.Println(err)
 		return subcommands.ExitUsageError
 	}
 
-	rate := pump.Rate(in*1000) * pump.MilliunitsPerHour
+	warnNoLimits(s.pump)
 
 	l := log.New(os.Stderr, "setrate: ", 0)
 	var done bool
@@ -119,6 +188,11 @@ func (s *setRateCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 			log.Print(err)
 			return subcommands.ExitFailure
 		}
+
+		// The pump's state won't change, so we'd never converge.
+		if s.pump.Dryrun() {
+			break
+		}
 	}
 
 	return subcommands.ExitSuccess
@@ -128,6 +202,13 @@ func main() {
 	log.SetPrefix("")
 	log.SetFlags(0)
 
+	subcommands.ImportantFlag("radio")
+	subcommands.ImportantFlag("dryrun")
+	subcommands.Register(subcommands.HelpCommand(), "")
+	subcommands.Register(subcommands.FlagsCommand(), "")
+
+	flag.Parse()
+
 	parts := strings.SplitN(*radioFlag, ":", 2)
 	if len(parts) != 2 {
 		flag.Usage()
@@ -140,14 +221,31 @@ func main() {
 		log.Fatal(err)
 	}
 
-	subcommands.ImportantFlag("radio")
-	subcommands.Register(subcommands.HelpCommand(), "")
-	subcommands.Register(subcommands.FlagsCommand(), "")
-	subcommands.Register(&statCmd{p}, "")
+	conf, err := loadConfig(*configFlag, flagSet("config"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.SetLimits(conf.Limits)
+	p.SetDryrun(*dryrunFlag)
+	p.SetClearWarn(*clearFlag)
+	p.SetDriftThreshold(*driftFlag)
+
+	if *tzFlag != "" {
+		loc, err := time.LoadLocation(*tzFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.SetLocation(loc)
+	}
+
+	subcommands.Register(&statCmd{pump: p}, "")
+	subcommands.Register(&status1Cmd{p}, "")
 	subcommands.Register(&cancelComboCmd{p}, "")
 	subcommands.Register(&setRateCmd{p}, "")
+	subcommands.Register(&bolusCmd{pump: p}, "")
+	subcommands.Register(&watchCmd{pump: p}, "")
+	subcommands.Register(&serveCmd{pump: p}, "")
 
-	flag.Parse()
 	ctx := context.Background()
 	os.Exit(int(subcommands.Execute(ctx)))
 }
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tinyap.org/ping/pump"
+	"tinyap.org/ping/radio"
+
+	"github.com/google/subcommands"
+	"golang.org/x/net/context"
+)
+
+type serveCmd struct {
+	pump   *pump.Pump
+	addr   string
+	socket string
+	token  string
+	maxAge time.Duration
+}
+
+func (*serveCmd) Name() string     { return "serve" }
+func (*serveCmd) Synopsis() string { return "Serve a local HTTP API to the pump" }
+func (*serveCmd) Usage() string {
+	return `serve [-socket path | -addr host:port [-token path]] [-maxage duration]:
+  Own the radio and pump, and serve a JSON HTTP API to them on a unix
+  socket (by default $TAP/pingrf.sock), or on a loopback address:
+
+    GET  /stat         the pump's state, cached for -maxage (?refresh=1 to force)
+    POST /setrate      {"rate": "0.8U/hr"}
+    POST /bolus        {"amount": "1U", "extended": "1h"}
+    POST /cancelcombo
+    GET  /radio        radio call statistics
+
+  POST requests must have Content-Type application/json. On a loopback
+  address, requests must name a loopback Host, and carry the header
+  "Authorization: Bearer <token>", with the token read from the -token
+  file (by default $TAP/pingrf.token). The file must be readable only
+  by its owner; if it does not exist, it is created with a new token.
+  Requests are handled one at a time, in the order they arrive.
+`
+}
+
+func (s *serveCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&s.socket, "socket", "", "The unix socket on which to serve (default $TAP/pingrf.sock).")
+	f.StringVar(&s.addr, "addr", "", "Serve on this loopback address (e.g. localhost:8734) instead.")
+	f.StringVar(&s.token, "token", "", "The file holding the bearer token for -addr (default $TAP/pingrf.token).")
+	f.DurationVar(&s.maxAge, "maxage", time.Minute, "How long a cached stat is served before the pump is queried again.")
+}
+
+// A session owns the pump. Its owner goroutine runs each operation
+// in turn, so that clients never interleave calls on the radio.
+type session struct {
+	pump *pump.Pump
+	ops  chan func()
+
+	// Owned by the owner goroutine.
+	stat     *pump.Stat
+	statTime time.Time
+}
+
+func newSession(p *pump.Pump) *session {
+	s := &session{pump: p, ops: make(chan func())}
+	go func() {
+		for op := range s.ops {
+			op()
+		}
+	}()
+	return s
+}
+
+// do runs op on the owner goroutine, and waits for it to finish.
+func (s *session) do(op func()) {
+	done := make(chan struct{})
+	s.ops <- func() {
+		defer close(done)
+		op()
+	}
+	<-done
+}
+
+// refresh queries the pump's state, and caches it. It must be called
+// by the owner goroutine.
+func (s *session) refresh() (*pump.Stat, error) {
+	stat, err := s.pump.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s.stat, s.statTime = stat, time.Now()
+	return stat, nil
+}
+
+func (s *serveCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	tap := os.Getenv("TAP")
+	if s.addr == "" && s.socket == "" {
+		if tap == "" {
+			log.Print("give -socket or -addr, or set $TAP")
+			return subcommands.ExitUsageError
+		}
+		s.socket = filepath.Join(tap, "pingrf.sock")
+	}
+	if s.addr != "" && s.token == "" {
+		if tap == "" {
+			log.Print("give -token, or set $TAP")
+			return subcommands.ExitUsageError
+		}
+		s.token = filepath.Join(tap, "pingrf.token")
+	}
+
+	var l net.Listener
+	var token string
+	var err error
+	if s.addr != "" {
+		if token, err = loadToken(s.token); err == nil {
+			l, err = listenLoopback(s.addr)
+		}
+	} else {
+		l, err = listenUnix(s.socket)
+	}
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	warnNoLimits(s.pump)
+
+	sess := newSession(s.pump)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stat", s.handleStat(sess))
+	mux.HandleFunc("/setrate", handleSetRate(sess))
+	mux.HandleFunc("/bolus", handleBolus(sess))
+	mux.HandleFunc("/cancelcombo", handleCancelCombo(sess))
+	mux.HandleFunc("/radio", handleRadio(sess))
+
+	log.Printf("serving on %s", l.Addr())
+	if err := http.Serve(l, guard(mux, token)); err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// listenUnix listens on a unix socket at path, which only its owner
+// may connect to.
+func listenUnix(path string) (net.Listener, error) {
+	// Remove a stale socket, but nothing else.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenLoopback listens on addr, which must be a loopback address.
+func listenLoopback(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := l.Addr().(*net.TCPAddr); !ok || !a.IP.IsLoopback() {
+		l.Close()
+		return nil, errors.New(fmt.Sprintf("%s is not a loopback address", addr))
+	}
+	return l, nil
+}
+
+// loadToken reads the bearer token from the file at path, which only
+// its owner may read. If there is no such file, it is created with a
+// new random token.
+func loadToken(path string) (string, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			f.Close()
+			return "", err
+		}
+		token := hex.EncodeToString(b)
+		_, err = fmt.Fprintln(f, token)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		log.Printf("wrote a new token to %s", path)
+		return token, nil
+	}
+	if !os.IsExist(err) {
+		return "", err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return "", errors.New(fmt.Sprintf("%s is readable by others; it must be mode 0600", path))
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", errors.New(fmt.Sprintf("%s holds no token", path))
+	}
+	return token, nil
+}
+
+// guard refuses requests that a web page could make on the user's
+// behalf. POST requests must be JSON, which a page cannot send to
+// another origin without a CORS preflight, which we never answer. On
+// a loopback address, where any local user may connect, token is set:
+// the Host must be a loopback name, to defeat DNS rebinding, and
+// requests must carry token as a bearer credential.
+func guard(h http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !loopbackHost(r.Host) {
+			replyError(w, http.StatusForbidden, "bad host "+r.Host)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			replyError(w, http.StatusUnauthorized, "missing or bad token")
+			return
+		}
+		if r.Method == "POST" {
+			ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || ct != "application/json" {
+				replyError(w, http.StatusUnsupportedMediaType, "POST requests must be application/json")
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loopbackHost tells whether host, from a request's Host header,
+// names the loopback interface.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+type statReply struct {
+	Stat *pump.Stat    `json:"stat"`
+	Age  time.Duration `json:"age_ns"`
+}
+
+func (s *serveCmd) handleStat(sess *session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			replyError(w, http.StatusMethodNotAllowed, "GET only")
+			return
+		}
+
+		var reply statReply
+		var err error
+		sess.do(func() {
+			stat := sess.stat
+			if stat == nil || time.Since(sess.statTime) > s.maxAge || r.FormValue("refresh") != "" {
+				if stat, err = sess.refresh(); err != nil {
+					return
+				}
+			}
+			reply = statReply{stat, time.Since(sess.statTime)}
+		})
+		if err != nil {
+			replyError(w, statusOf(err), err.Error())
+			return
+		}
+		replyJSON(w, http.StatusOK, &reply)
+	}
+}
+
+// The most passes SetRate is given to converge.
+const setRateTries = 5
+
+func handleSetRate(sess *session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Rate *pump.Rate `json:"rate"`
+		}
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		if req.Rate == nil {
+			replyError(w, http.StatusBadRequest, "missing rate")
+			return
+		}
+
+		warnNoLimits(sess.pump)
+		l := log.New(os.Stderr, "setrate: ", 0)
+		var done bool
+		var err error
+		sess.do(func() {
+			for i := 0; i < setRateTries && !done && err == nil; i++ {
+				done, err = sess.pump.SetRate(l, *req.Rate)
+				if sess.pump.Dryrun() {
+					break
+				}
+			}
+			sess.stat = nil
+		})
+		if err != nil {
+			replyError(w, statusOf(err), err.Error())
+			return
+		}
+		replyJSON(w, http.StatusOK, map[string]bool{"done": done})
+	}
+}
+
+func handleBolus(sess *session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Amount   *pump.Amount `json:"amount"`
+			Extended string       `json:"extended"`
+		}
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		if req.Amount == nil {
+			replyError(w, http.StatusBadRequest, "missing amount")
+			return
+		}
+		var dur time.Duration
+		if req.Extended != "" {
+			var err error
+			if dur, err = time.ParseDuration(req.Extended); err != nil {
+				replyError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		warnNoLimits(sess.pump)
+
+		// Boluses go through BolusOnce, so that an ambiguous failure
+		// is reconciled rather than retried blindly. An intent that
+		// BolusOnce would refuse is the client's error, so it is
+		// refused here first.
+		outcome := pump.OutcomeNotDelivered
+		status := http.StatusOK
+		var err error
+		sess.do(func() {
+			var in *pump.BolusIntent
+			if in, err = sess.pump.NewBolusIntent(*req.Amount, dur); err != nil {
+				status = statusOf(err)
+				return
+			}
+			if err = in.Validate(); err != nil {
+				status = http.StatusBadRequest
+				return
+			}
+			if err = in.Check(); err != nil {
+				status = http.StatusConflict
+				return
+			}
+			if outcome, err = sess.pump.BolusOnce(in); err != nil {
+				status = statusOf(err)
+			}
+			sess.stat = nil
+		})
+
+		reply := map[string]string{"outcome": outcome.String()}
+		if err != nil {
+			reply["error"] = err.Error()
+			replyJSON(w, status, reply)
+			return
+		}
+		replyJSON(w, http.StatusOK, reply)
+	}
+}
+
+func handleCancelCombo(sess *session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			replyError(w, http.StatusMethodNotAllowed, "POST only")
+			return
+		}
+
+		var err error
+		sess.do(func() {
+			err = sess.pump.CancelCombo()
+			sess.stat = nil
+		})
+		if err != nil {
+			replyError(w, statusOf(err), err.Error())
+			return
+		}
+		replyJSON(w, http.StatusOK, map[string]bool{"done": true})
+	}
+}
+
+func handleRadio(sess *session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			replyError(w, http.StatusMethodNotAllowed, "GET only")
+			return
+		}
+
+		var stats radio.Stats
+		sess.do(func() {
+			stats = sess.pump.Radio().Stats()
+		})
+		replyJSON(w, http.StatusOK, &stats)
+	}
+}
+
+// decodeRequest decodes the JSON body of a POST request into v. It
+// replies with an error, and returns false, if it cannot.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != "POST" {
+		replyError(w, http.StatusMethodNotAllowed, "POST only")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		replyError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// statusOf maps an error from the pump to an HTTP status. Requests
+// refused by the safety limits are forbidden, and those refused for an
+// active warning conflict with the pump's state; anything else is a
+// failure to talk to the pump.
+func statusOf(err error) int {
+	if _, ok := err.(*pump.LimitError); ok {
+		return http.StatusForbidden
+	}
+	if err == pump.ErrWarning {
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+func replyError(w http.ResponseWriter, status int, msg string) {
+	replyJSON(w, status, map[string]string{"error": msg})
+}
+
+func replyJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := writeJSON(w, v); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		host, method, ctype string
+		token, auth         string
+		expected            int
+	}{
+		{"localhost:8734", "POST", "application/json", "t0k", "Bearer t0k", http.StatusOK},
+		{"127.0.0.1:8734", "POST", "application/json; charset=utf-8", "t0k", "Bearer t0k", http.StatusOK},
+		{"[::1]:8734", "GET", "", "t0k", "Bearer t0k", http.StatusOK},
+		{"evil.example:8734", "GET", "", "t0k", "Bearer t0k", http.StatusForbidden},
+		{"evil.example", "GET", "", "", "", http.StatusOK},
+		{"localhost:8734", "GET", "", "t0k", "", http.StatusUnauthorized},
+		{"localhost:8734", "POST", "application/json", "t0k", "Bearer t0", http.StatusUnauthorized},
+		{"localhost:8734", "POST", "text/plain", "t0k", "Bearer t0k", http.StatusUnsupportedMediaType},
+		{"localhost:8734", "POST", "", "", "", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "http://"+test.host+"/bolus", strings.NewReader(`{"amount": "1U"}`))
+		r.Host = test.host
+		if test.ctype != "" {
+			r.Header.Set("Content-Type", test.ctype)
+		}
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+
+		w := httptest.NewRecorder()
+		guard(ok, test.token).ServeHTTP(w, r)
+		if w.Code != test.expected {
+			t.Errorf("%s %s %q %q: expected %d, got %d", test.method, test.host, test.ctype, test.auth, test.expected, w.Code)
+		}
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pingrf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pingrf.token")
+
+	token, err := loadToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("bad token file %v (%v)", fi.Mode(), err)
+	}
+
+	if again, err := loadToken(path); err != nil || again != token {
+		t.Errorf("expected %q, got %q (%v)", token, again, err)
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadToken(path); err == nil {
+		t.Error("expected error for token file readable by others")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"tinyap.org/ping/pump"
+
+	"github.com/google/subcommands"
+	"golang.org/x/net/context"
+)
+
+type watchCmd struct {
+	pump     *pump.Pump
+	interval time.Duration
+	out      string
+}
+
+func (*watchCmd) Name() string     { return "watch" }
+func (*watchCmd) Synopsis() string { return "Monitor the pump, printing changes" }
+func (*watchCmd) Usage() string {
+	return `watch [-interval duration] [-out file]:
+  Poll the pump's state, and print what changes. Each snapshot is
+  appended to the output file as a line of JSON. After a radio
+  failure, polling backs off up to the interval.
+`
+}
+
+func (w *watchCmd) SetFlags(f *flag.FlagSet) {
+	f.DurationVar(&w.interval, "interval", 5*time.Minute, "How often to poll the pump.")
+	f.StringVar(&w.out, "out", "", "Append each snapshot to this JSON-lines file.")
+}
+
+// The first delay after a failed poll, unless the polling interval is
+// shorter; it doubles with each further failure, up to the interval.
+const watchBackoff = 15 * time.Second
+
+func (w *watchCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if w.interval <= 0 {
+		return subcommands.ExitUsageError
+	}
+
+	var out *os.File
+	if w.out != "" {
+		var err error
+		out, err = os.OpenFile(w.out, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Print(err)
+			return subcommands.ExitFailure
+		}
+		defer out.Close()
+	}
+
+	first := watchBackoff
+	if first > w.interval {
+		first = w.interval
+	}
+
+	var last *pump.Stat
+	backoff := first
+
+	for {
+		delay := w.interval
+
+		stat, err := w.pump.Stat()
+		if err != nil {
+			log.Printf("%s: %s; retrying in %s", time.Now().Format(time.Stamp), err, backoff)
+			delay = backoff
+			if backoff *= 2; backoff > w.interval {
+				backoff = w.interval
+			}
+		} else {
+			backoff = first
+			printChanges(last, stat)
+			last = stat
+
+			if out != nil {
+				if err := writeJSON(out, stat); err != nil {
+					log.Print(err)
+					return subcommands.ExitFailure
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return subcommands.ExitSuccess
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watched returns the fields of a Stat that watch reports, in order.
+func watched(s *pump.Stat) [][2]string {
+	combo := "off"
+	if s.ComboActive {
+		combo = fmt.Sprintf("%s/%s until %s", s.ComboDelivered, s.ComboTotal, s.ComboEnd.Format(time.Kitchen))
+	}
+	temp := "off"
+	if s.Temp != 0 {
+		temp = fmt.Sprintf("%d%% until %s", s.Temp, s.TempEnd.Format(time.Kitchen))
+	}
+
+	return [][2]string{
+		{"basal", s.Basal.String()},
+		{"temp", temp},
+		{"reservoir", s.Reservoir.String()},
+		{"IOB", s.IOB.String()},
+		{"last bolus", fmt.Sprintf("%s at %s", s.LastBolus, s.LastBolusTime.Format(time.Stamp))},
+		{"combo", combo},
+		{"suspended", fmt.Sprint(s.Suspended)},
+		{"warning", fmt.Sprint(s.Warn)},
+		{"clock drift", fmt.Sprint(s.DriftWarn)},
+	}
+}
+
+// printChanges prints the watched fields of stat that differ from
+// last; or all of them, if last is nil.
+func printChanges(last, stat *pump.Stat) {
+	now := stat.Now.Format(time.Stamp)
+
+	fields := watched(stat)
+	if last == nil {
+		for _, f := range fields {
+			fmt.Printf("%s %s %s\n", now, f[0], f[1])
+		}
+		return
+	}
+
+	for i, f := range watched(last) {
+		if f[1] != fields[i][1] {
+			fmt.Printf("%s %s %s -> %s\n", now, f[0], f[1], fields[i][1])
+		}
+	}
+}
@@ -24,14 +24,30 @@ func gstring(b []byte) (string, []byte) {
 	return string(b[0:n]), b[n:]
 }
 
-func gtime(b []byte) (time.Time, []byte) {
+// Pump timestamps encode the year in four bits, as an offset from
+// 2007, and so identify it only modulo 16. The year is inferred as the
+// latest one that matches and is no more than a year past the
+// reference time, allowing for a pump clock that runs ahead. The
+// pump's wall clock is interpreted in the Clock's location.
+func gtime(b []byte, c Clock) (time.Time, []byte) {
+	ref := c.Ref
+	if ref.IsZero() {
+		ref = time.Now()
+	}
+
+	loc := c.Loc
+	if loc == nil {
+		loc = time.Local
+	}
+
 	yearmonth, b := gbit8(b)
-	year := 2007 + int(yearmonth&0xf)
+	max := ref.Year() + 1
+	year := max - ((max-2007-int(yearmonth&0xf))%16+16)%16
 	month := time.Month(1 + yearmonth>>4)
 	day, b := gbit8(b)
 	hour, b := gbit8(b)
 	min, b := gbit8(b)
-	return time.Date(year, month, int(day), int(hour), int(min), 0, 0, time.Local), b
+	return time.Date(year, month, int(day), int(hour), int(min), 0, 0, loc), b
 }
 
 func gdur(b []byte) (time.Duration, []byte) {
@@ -80,6 +96,22 @@ func pbit32(b []byte, x uint32) []byte {
 	return b
 }
 
+// ptime encodes t on the pump's wall clock, in the Clock's location.
+func ptime(b []byte, t time.Time, c Clock) []byte {
+	if c.Loc != nil {
+		t = t.In(c.Loc)
+	} else {
+		t = t.In(time.Local)
+	}
+
+	year := ((t.Year()-2007)%16 + 16) % 16
+	b = pbit8(b, uint8(year)|uint8(t.Month()-1)<<4)
+	b = pbit8(b, uint8(t.Day()))
+	b = pbit8(b, uint8(t.Hour()))
+	b = pbit8(b, uint8(t.Minute()))
+	return b
+}
+
 func pbit32be(b []byte, x uint32) []byte {
 	n := len(b)
 	if n+4 > cap(b) {
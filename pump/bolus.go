@@ -0,0 +1,272 @@
+package pump
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//go:generate stringer -type=Outcome
+//go:generate stringer -type=BolusStage -trimprefix=Stage
+
+// ErrBolusCancelled is returned when a bolus is cancelled while it is
+// being delivered.
+var ErrBolusCancelled = errors.New("bolus cancelled")
+
+// BolusStage is a stage in the delivery of a bolus.
+type BolusStage uint8
+
+const (
+	StageAccepted     BolusStage = 0 + iota // The pump confirmed the bolus
+	StageAcknowledged                       // Delivery was acknowledged
+	StageBusy                               // The pump is delivering
+	StageDone                               // Delivery is complete
+	StageCancelled                          // Delivery was cancelled
+)
+
+// A BolusEvent reports the progress of a bolus.
+type BolusEvent struct {
+	Stage BolusStage
+	Bolus Amount // The requested bolus
+}
+
+func (e BolusEvent) String() string {
+	return fmt.Sprintf("%s %s", e.Stage, e.Bolus)
+}
+
+// Outcome is the definitive result of a bolus issued through
+// BolusOnce.
+type Outcome uint8
+
+const (
+	OutcomeUnknown Outcome = 0 + iota
+	OutcomeDelivered
+	OutcomeNotDelivered
+	OutcomeDryrun // Logged in dry-run mode, but not transmitted
+)
+
+// The number of times BolusOnce will transmit a bolus that the pump
+// has positively not delivered.
+const bolusTries = 3
+
+// A BolusIntent records a bolus before it is transmitted, together
+// with the pump state observed beforehand. When a bolus fails
+// ambiguously -- after the Bolus call, but before delivery is
+// confirmed -- the intent is reconciled against the pump's own
+// record of its last bolus (Status2) and combo (Status4).
+type BolusIntent struct {
+	Bolus    Amount
+	Duration time.Duration
+
+	Before *Stat // Pump state before the bolus was transmitted
+}
+
+func (in *BolusIntent) String() string {
+	return fmt.Sprintf("BolusIntent %s %s", in.Bolus, in.Duration)
+}
+
+// NewBolusIntent records the intent to deliver the given bolus
+// (extended over dur, if nonzero), capturing the pump's current state.
+func (p *Pump) NewBolusIntent(bolus Amount, dur time.Duration) (*BolusIntent, error) {
+	before, err := p.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &BolusIntent{Bolus: bolus, Duration: dur, Before: before}, nil
+}
+
+// Validate checks that the intended bolus can be expressed in a
+// Bolus call.
+func (in *BolusIntent) Validate() error {
+	return validateBolus(in.Bolus, in.Duration)
+}
+
+// Check refuses an intent that could not be reconciled. Pump
+// timestamps have a resolution of one minute: if the last bolus (or
+// combo) began in the current pump minute, a new one with the same
+// amount cannot be told apart from it. Callers that deliver an intent
+// other than through BolusOnce must check it first.
+func (in *BolusIntent) Check() error {
+	now := in.Before.Now.Truncate(time.Minute)
+	last := in.Before.LastBolusTime
+	if in.Duration != 0 {
+		last = in.Before.ComboBegin
+	}
+
+	if !last.Before(now) {
+		return errors.New("a bolus began this minute; cannot reconcile another")
+	}
+	return nil
+}
+
+// Reconcile decides, from the pump's state after a failed bolus,
+// whether the intended bolus was delivered. Normal boluses are
+// matched against the last bolus; extended boluses against the combo.
+// Any change that does not exactly match the intent is reported as
+// OutcomeUnknown.
+func (in *BolusIntent) Reconcile(after *Stat) Outcome {
+	before := in.Before
+
+	if in.Duration == 0 {
+		if after.LastBolusTime.Equal(before.LastBolusTime) && after.LastBolus == before.LastBolus {
+			return OutcomeNotDelivered
+		}
+		if after.LastBolus == in.Bolus {
+			return OutcomeDelivered
+		}
+		return OutcomeUnknown
+	}
+
+	if after.ComboBegin.Equal(before.ComboBegin) && after.ComboTotal == before.ComboTotal {
+		return OutcomeNotDelivered
+	}
+	if after.ComboTotal == in.Bolus {
+		return OutcomeDelivered
+	}
+	return OutcomeUnknown
+}
+
+// Reconcile reads the pump's state and reconciles the intent against it.
+func (p *Pump) Reconcile(in *BolusIntent) (Outcome, error) {
+	after, err := p.Stat()
+	if err != nil {
+		return OutcomeUnknown, err
+	}
+	return in.Reconcile(after), nil
+}
+
+// BolusOnce delivers the intended bolus at most once. A failure
+// before the bolus is acknowledged is reconciled against the pump: the
+// bolus is retransmitted only when the pump positively did not deliver
+// it. A failure once the bolus is acknowledged is never retried, since
+// the pump may still be delivering it. BolusOnce returns
+// OutcomeUnknown (along with the original error) when the outcome
+// cannot be decided; the caller may later retry reconciliation with
+// Reconcile, but must not issue the bolus again until it has. In
+// dry-run mode, a bolus that was logged instead of transmitted is
+// OutcomeDryrun.
+func (p *Pump) BolusOnce(in *BolusIntent) (Outcome, error) {
+	// Retrying would not change these.
+	if err := in.Validate(); err != nil {
+		return OutcomeNotDelivered, err
+	}
+	if err := in.Check(); err != nil {
+		return OutcomeNotDelivered, err
+	}
+	if err := p.limits.Check(in.Before, in.Bolus, in.Duration); err != nil {
+		return OutcomeNotDelivered, err
+	}
+
+	for try := 0; ; try++ {
+		acked, err := p.bolus(in.Bolus, in.Duration, nil, nil)
+		switch {
+		case err == nil && p.dryrun:
+			return OutcomeDryrun, nil
+		case err == nil:
+			return OutcomeDelivered, nil
+		case acked:
+			return OutcomeUnknown, err
+		}
+		if _, ok := err.(*mismatchError); ok {
+			return OutcomeNotDelivered, err
+		}
+
+		after, serr := p.Stat()
+		if serr != nil {
+			return OutcomeUnknown, fmt.Errorf("%s (reconcile: %s)", err, serr)
+		}
+
+		switch in.Reconcile(after) {
+		case OutcomeDelivered:
+			return OutcomeDelivered, nil
+		case OutcomeUnknown:
+			return OutcomeUnknown, err
+		}
+
+		if try == bolusTries-1 {
+			return OutcomeNotDelivered, err
+		}
+
+		// The pump did not deliver; it is safe to try again.
+		in.Before = after
+	}
+}
+
+// The largest bolus, and the longest extension, that a Bolus call
+// can express: the bolus in 16 bits of milliunits, the extension in 8
+// bits of 6 minutes.
+const (
+	maxBolus    = 0xffff * Milliunit
+	maxDuration = 0xff * 6 * time.Minute
+)
+
+// validateBolus checks that a bolus, extended over dur if nonzero,
+// can be expressed in a Bolus call.
+func validateBolus(bolus Amount, dur time.Duration) error {
+	if dur < 0 || dur%(6*time.Minute) != 0 {
+		return errors.New("combo duration must be increments of 6 minutes")
+	}
+	if dur > maxDuration {
+		return errors.New(fmt.Sprintf("combo duration %s exceeds %s", dur, maxDuration))
+	}
+	return bolus.ValidateBolus()
+}
+
+func validateDual(immediate, extended Amount, dur time.Duration) error {
+	if dur == 0 {
+		return errors.New("combo duration must be increments of 6 minutes")
+	}
+	if err := validateBolus(immediate, 0); err != nil {
+		return err
+	}
+	return validateBolus(extended, dur)
+}
+
+// A mismatchError reports that the pump echoed a Bolus call other
+// than the one sent. Such a bolus is never acknowledged.
+type mismatchError struct {
+	sent, reply Bolus
+}
+
+func (e *mismatchError) Error() string {
+	return fmt.Sprintf("pump returned mismatched bolus response: %s, expected %s", &e.reply, &e.sent)
+}
+
+// DualBolus delivers a dual-wave bolus: an immediate portion, followed
+// by an extended portion delivered over dur. The remote encodes both
+// portions in a single Bolus call, but that encoding has not been
+// captured; DualBolus instead issues a normal bolus followed by an
+// extended one, each through BolusOnce, and returns the outcome of
+// each. The extended portion is issued only once the immediate portion
+// is known to have been delivered (or logged, in dry-run mode);
+// otherwise it is OutcomeNotDelivered.
+func (p *Pump) DualBolus(immediate, extended Amount, dur time.Duration) (first, second Outcome, err error) {
+	first, second = OutcomeNotDelivered, OutcomeNotDelivered
+	if err = validateDual(immediate, extended, dur); err != nil {
+		return
+	}
+
+	in, err := p.NewBolusIntent(immediate, 0)
+	if err != nil {
+		return
+	}
+	if in.Before.ComboActive {
+		err = errors.New("refusing dual-wave bolus while a combo is active")
+		return
+	}
+	if err = p.limits.CheckDual(in.Before, immediate, extended, dur); err != nil {
+		return
+	}
+
+	if first, err = p.BolusOnce(in); first != OutcomeDelivered && first != OutcomeDryrun {
+		return
+	}
+
+	if in, err = p.NewBolusIntent(extended, dur); err != nil {
+		return
+	}
+	if second, err = p.BolusOnce(in); err != nil {
+		err = errors.New(fmt.Sprintf("immediate %s delivered, but extended: %s", immediate, err))
+	}
+	return
+}
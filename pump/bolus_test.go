@@ -0,0 +1,103 @@
+package pump
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	reconcileNow  = time.Date(2016, 6, 5, 18, 30, 0, 0, time.Local)
+	reconcileLast = time.Date(2016, 6, 5, 18, 9, 0, 0, time.Local)
+)
+
+func reconcileBefore() *Stat {
+	return &Stat{
+		Now:           reconcileNow,
+		LastBolus:     250 * Milliunit,
+		LastBolusTime: reconcileLast,
+		ComboBegin:    reconcileLast,
+		ComboEnd:      reconcileLast.Add(4 * time.Hour),
+		ComboTotal:    1 * Unit,
+	}
+}
+
+func TestBolusIntent_Reconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		bolus    Amount
+		dur      time.Duration
+		after    func(s *Stat)
+		expected Outcome
+	}{
+		{"normal unchanged", 500 * Milliunit, 0, func(s *Stat) {}, OutcomeNotDelivered},
+		{"normal delivered", 500 * Milliunit, 0, func(s *Stat) {
+			s.LastBolus = 500 * Milliunit
+			s.LastBolusTime = reconcileNow
+		}, OutcomeDelivered},
+		{"normal partial", 500 * Milliunit, 0, func(s *Stat) {
+			s.LastBolus = 100 * Milliunit
+			s.LastBolusTime = reconcileNow
+		}, OutcomeUnknown},
+		{"normal same amount", 250 * Milliunit, 0, func(s *Stat) {
+			s.LastBolusTime = reconcileNow
+		}, OutcomeDelivered},
+		{"combo unchanged", 2 * Unit, time.Hour, func(s *Stat) {}, OutcomeNotDelivered},
+		{"combo delivered", 2 * Unit, time.Hour, func(s *Stat) {
+			s.ComboActive = true
+			s.ComboBegin = reconcileNow
+			s.ComboTotal = 2 * Unit
+		}, OutcomeDelivered},
+		{"combo other", 2 * Unit, time.Hour, func(s *Stat) {
+			s.ComboActive = true
+			s.ComboBegin = reconcileNow
+			s.ComboTotal = 3 * Unit
+		}, OutcomeUnknown},
+	}
+
+	for _, test := range tests {
+		in := &BolusIntent{Bolus: test.bolus, Duration: test.dur, Before: reconcileBefore()}
+		after := reconcileBefore()
+		test.after(after)
+		if got := in.Reconcile(after); got != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestBolusIntent_Check(t *testing.T) {
+	in := &BolusIntent{Bolus: 500 * Milliunit, Before: reconcileBefore()}
+	if err := in.Check(); err != nil {
+		t.Error(err)
+	}
+
+	in.Before.LastBolusTime = reconcileNow
+	if err := in.Check(); err == nil {
+		t.Error("expected error for bolus in the current minute")
+	}
+
+	in.Duration = time.Hour
+	if err := in.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateDual(t *testing.T) {
+	if err := validateDual(1*Unit, 2*Unit, 2*time.Hour); err != nil {
+		t.Error(err)
+	}
+	if err := validateDual(0, 2*Unit, 2*time.Hour); err == nil {
+		t.Error("expected error for empty immediate portion")
+	}
+	if err := validateDual(1*Unit, 2010*Milliunit, 2*time.Hour); err == nil {
+		t.Error("expected error for extended portion off increment")
+	}
+	if err := validateDual(1*Unit, 2*Unit, 0); err == nil {
+		t.Error("expected error for zero duration")
+	}
+	if err := validateDual(1*Unit, 2*Unit, 10*time.Minute); err == nil {
+		t.Error("expected error for duration off increment")
+	}
+	if err := validateDual(1*Unit, 2*Unit, 26*time.Hour); err == nil {
+		t.Error("expected error for duration beyond 255 increments")
+	}
+}
@@ -0,0 +1,378 @@
+package pump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"tinyap.org/ping/radio"
+)
+
+// An emulator stands in for the radio and the pump behind it. It
+// replies to each call with the body registered for its type, and
+// records the calls it receives.
+type emulator struct {
+	t *testing.T
+
+	bodies map[uint8][]byte
+	calls  []uint8
+
+	// Called with each call received, before it is replied to.
+	handle func(e *emulator, typ uint8, body []byte)
+
+	rbuf bytes.Buffer
+}
+
+func newEmulator(t *testing.T) (*emulator, *Pump) {
+	fakeChecksums()
+
+	e := &emulator{
+		t: t,
+		bodies: map[uint8][]byte{
+			CallStatus:         status_NoTemp,
+			CallStatus2:        status2,
+			CallStatus3:        status3,
+			CallStatus4:        status4_cancelled,
+			CallDeliverystatus: {0x00, 0x02},
+		},
+	}
+	return e, New(radio.New(e))
+}
+
+func (e *emulator) Write(b []byte) (int, error) {
+	n := len(b)
+	req, err := radio.UnmarshalRcall(b)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	rep := &radio.Call{Type: req.Type + 1}
+
+	var tx frame
+	if err := tx.Unmarshal(req.Pkt[:]); err != nil {
+		e.t.Fatal(err)
+	}
+	e.calls = append(e.calls, tx.Type)
+	if e.handle != nil {
+		e.handle(e, tx.Type, tx.Body)
+	}
+
+	if req.Type == radio.Ttxrx {
+		rx := &frame{Type: tx.Type, Tag: tx.Tag ^ 0xff, Body: e.bodies[tx.Type]}
+		pkt, err := rx.Marshal()
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		copy(rep.Pkt[:], pkt)
+	}
+
+	b, err = rep.Bytes()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.rbuf.Write(b)
+
+	return n, nil
+}
+
+func (e *emulator) Read(b []byte) (int, error) {
+	return e.rbuf.Read(b)
+}
+
+// called tells whether the emulator received a call of type typ.
+func (e *emulator) called(typ uint8) bool {
+	for _, typ1 := range e.calls {
+		if typ1 == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEmulator_Stat(t *testing.T) {
+	_, p := newEmulator(t)
+
+	stat, err := p.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Basal != 250*MilliunitsPerHour || stat.LastBolus != 250*Milliunit || stat.ComboActive {
+		t.Errorf("bad stat %s", stat)
+	}
+}
+
+func TestPump_ClearWarn(t *testing.T) {
+	e, p := newEmulator(t)
+	e.bodies[CallStatus] = status_warn
+	e.bodies[CallStatus4] = status4
+	e.handle = func(e *emulator, typ uint8, body []byte) {
+		if typ == CallClearwarn {
+			e.bodies[CallStatus] = status_NoTemp
+		}
+	}
+
+	if err := p.ClearWarn(); err != nil {
+		t.Fatal(err)
+	}
+	if !e.called(CallClearwarn) {
+		t.Error("Clearwarn was not called")
+	}
+	if e.called(CallCancelcombo) {
+		t.Error("Cancelcombo was called")
+	}
+}
+
+func TestPump_ClearWarn_persists(t *testing.T) {
+	e, p := newEmulator(t)
+	e.bodies[CallStatus] = status_warn
+
+	if err := p.ClearWarn(); err == nil {
+		t.Error("expected error for warning that was not cleared")
+	}
+}
+
+func TestPump_ClearWarn_combo(t *testing.T) {
+	e, p := newEmulator(t)
+	e.bodies[CallStatus] = status_warn
+	e.bodies[CallStatus4] = status4
+	e.handle = func(e *emulator, typ uint8, body []byte) {
+		if typ == CallClearwarn {
+			e.bodies[CallStatus] = status_NoTemp
+			e.bodies[CallStatus4] = status4_cancelled
+		}
+	}
+
+	if err := p.ClearWarn(); err == nil {
+		t.Error("expected error for combo cancelled by clearing warning")
+	}
+}
+
+func TestPump_SetRate_warn(t *testing.T) {
+	e, p := newEmulator(t)
+	e.bodies[CallStatus] = status_warn
+	l := log.New(ioutil.Discard, "", 0)
+
+	if _, err := p.SetRate(l, 500*MilliunitsPerHour); err != ErrWarning {
+		t.Errorf("expected %v, got %v", ErrWarning, err)
+	}
+	if e.called(CallClearwarn) {
+		t.Error("Clearwarn was called")
+	}
+
+	p.SetClearWarn(true)
+	p.SetRate(l, 500*MilliunitsPerHour)
+	if !e.called(CallClearwarn) {
+		t.Error("Clearwarn was not called")
+	}
+}
+
+func TestPump_BolusProgress_normalCancel(t *testing.T) {
+	e, p := newEmulator(t)
+
+	if err := p.BolusProgress(250*Milliunit, 0, nil, make(chan struct{})); err == nil {
+		t.Error("expected error for cancellable normal bolus")
+	}
+	if e.called(CallBolus) {
+		t.Error("Bolus was called")
+	}
+}
+
+func TestPump_DualBolus_combo(t *testing.T) {
+	e, p := newEmulator(t)
+	e.bodies[CallStatus4] = status4
+
+	first, second, err := p.DualBolus(500*Milliunit, 1*Unit, time.Hour)
+	if err == nil {
+		t.Error("expected error for dual-wave bolus during a combo")
+	}
+	if first != OutcomeNotDelivered || second != OutcomeNotDelivered {
+		t.Errorf("expected %s, got %s and %s", OutcomeNotDelivered, first, second)
+	}
+	if e.called(CallBolus) {
+		t.Error("Bolus was called")
+	}
+}
+
+func TestPump_BolusOnce_dryrun(t *testing.T) {
+	e, p := newEmulator(t)
+	p.SetDryrun(true)
+
+	in := &BolusIntent{Bolus: 500 * Milliunit, Before: reconcileBefore()}
+	outcome, err := p.BolusOnce(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeDryrun {
+		t.Errorf("expected %s, got %s", OutcomeDryrun, outcome)
+	}
+	if e.called(CallBolus) {
+		t.Error("Bolus was transmitted")
+	}
+}
+
+// status2 after a bolus of 500mU at 18:18.
+var status2_bolus = mustDecode("01290100f40159051212a0860100b88818003b0400000700")
+
+// echoBolus makes the emulator accept each Bolus call after the first
+// n, which it answers with an empty body.
+func echoBolus(n int) func(e *emulator, typ uint8, body []byte) {
+	return func(e *emulator, typ uint8, body []byte) {
+		if typ != CallBolus {
+			return
+		}
+		if n > 0 {
+			n--
+			e.bodies[CallBolus] = nil
+			return
+		}
+		// The reply carries the amount, and the duration in 16 bits.
+		e.bodies[CallBolus] = []byte{body[0], body[1], body[2], body[3], body[6], 0}
+	}
+}
+
+// bolusCalls counts the Bolus calls the emulator received.
+func (e *emulator) bolusCalls() int {
+	n := 0
+	for _, typ := range e.calls {
+		if typ == CallBolus {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPump_BolusOnce(t *testing.T) {
+	e, p := newEmulator(t)
+	p.SetTimeRef(func() time.Time { return captureRef })
+	e.handle = echoBolus(0)
+
+	in := &BolusIntent{Bolus: 500 * Milliunit, Before: reconcileBefore()}
+	outcome, err := p.BolusOnce(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeDelivered {
+		t.Errorf("expected %s, got %s", OutcomeDelivered, outcome)
+	}
+	if n := e.bolusCalls(); n != 1 {
+		t.Errorf("expected 1 Bolus call, got %d", n)
+	}
+}
+
+func TestPump_BolusOnce_retry(t *testing.T) {
+	e, p := newEmulator(t)
+	p.SetTimeRef(func() time.Time { return captureRef })
+	e.handle = echoBolus(1)
+
+	// Status2 is unchanged by the failed call, so it is retried.
+	in := &BolusIntent{Bolus: 500 * Milliunit, Before: reconcileBefore()}
+	outcome, err := p.BolusOnce(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeDelivered {
+		t.Errorf("expected %s, got %s", OutcomeDelivered, outcome)
+	}
+	if n := e.bolusCalls(); n != 2 {
+		t.Errorf("expected 2 Bolus calls, got %d", n)
+	}
+}
+
+func TestPump_BolusOnce_reconciled(t *testing.T) {
+	e, p := newEmulator(t)
+	p.SetTimeRef(func() time.Time { return captureRef })
+	e.handle = func(e *emulator, typ uint8, body []byte) {
+		if typ == CallBolus {
+			e.bodies[CallStatus2] = status2_bolus
+		}
+	}
+
+	// Status2 records the bolus despite the failed call, so it is
+	// not retried.
+	in := &BolusIntent{Bolus: 500 * Milliunit, Before: reconcileBefore()}
+	outcome, err := p.BolusOnce(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeDelivered {
+		t.Errorf("expected %s, got %s", OutcomeDelivered, outcome)
+	}
+	if n := e.bolusCalls(); n != 1 {
+		t.Errorf("expected 1 Bolus call, got %d", n)
+	}
+}
+
+func TestPump_BolusOnce_acked(t *testing.T) {
+	e, p := newEmulator(t)
+	p.SetTimeRef(func() time.Time { return captureRef })
+	e.handle = echoBolus(0)
+	e.bodies[CallDeliverystatus] = nil
+
+	// The bolus failed after it was acknowledged, so the pump may
+	// yet deliver it.
+	in := &BolusIntent{Bolus: 500 * Milliunit, Before: reconcileBefore()}
+	outcome, err := p.BolusOnce(in)
+	if err == nil {
+		t.Error("expected error")
+	}
+	if outcome != OutcomeUnknown {
+		t.Errorf("expected %s, got %s", OutcomeUnknown, outcome)
+	}
+	if n := e.bolusCalls(); n != 1 {
+		t.Errorf("expected 1 Bolus call, got %d", n)
+	}
+}
+
+func TestPump_BolusOnce_refused(t *testing.T) {
+	tests := []struct {
+		name   string
+		bolus  Amount
+		dur    time.Duration
+		handle func(e *emulator, typ uint8, body []byte)
+		calls  int
+	}{
+		{"increment", 520 * Milliunit, 0, nil, 0},
+		{"duration", 500 * Milliunit, 5 * time.Minute, nil, 0},
+		{"long duration", 500 * Milliunit, 256 * 6 * time.Minute, nil, 0},
+		{"mismatch", 500 * Milliunit, 0, func(e *emulator, typ uint8, body []byte) {
+			e.bodies[CallBolus] = []byte{0, 0, 0xfa, 0, 0, 0}
+		}, 1},
+	}
+
+	for _, test := range tests {
+		e, p := newEmulator(t)
+		p.SetTimeRef(func() time.Time { return captureRef })
+		e.handle = test.handle
+
+		in := &BolusIntent{Bolus: test.bolus, Duration: test.dur, Before: reconcileBefore()}
+		outcome, err := p.BolusOnce(in)
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if outcome != OutcomeNotDelivered {
+			t.Errorf("%s: expected %s, got %s", test.name, OutcomeNotDelivered, outcome)
+		}
+		if n := e.bolusCalls(); n != test.calls {
+			t.Errorf("%s: expected %d Bolus calls, got %d", test.name, test.calls, n)
+		}
+		if e.called(CallStatus2) {
+			t.Errorf("%s: reconciled", test.name)
+		}
+	}
+}
+
+func TestPump_RadioStats(t *testing.T) {
+	_, p := newEmulator(t)
+
+	if _, err := p.Stat(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p.Radio().Stats()
+	if stats.Calls == 0 || stats.Errors != 0 || stats.Rerrs != 0 {
+		t.Errorf("bad stats %+v", stats)
+	}
+}
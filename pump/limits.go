@@ -0,0 +1,95 @@
+package pump
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits is a safety policy that is checked before any delivery call
+// is transmitted to the pump. Limits that are zero are not enforced.
+type Limits struct {
+	MaxBolus Amount `json:"max_bolus"` // Largest normal bolus
+	MaxCombo Amount `json:"max_combo"` // Largest total of an extended bolus
+	MaxRate  Rate   `json:"max_rate"`  // Largest effective rate: basal, temp and combo
+	MaxDaily Amount `json:"max_daily"` // Largest daily insulin, including the bolus
+	MaxIOB   Amount `json:"max_iob"`   // Largest insulin on board, including the bolus
+}
+
+// A LimitError is returned when a delivery is refused because it
+// would exceed one of the configured Limits.
+type LimitError struct {
+	Limit     string // Name of the exceeded limit
+	Requested fmt.Stringer
+	Max       fmt.Stringer
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("refused: %s %s exceeds limit of %s", e.Limit, e.Requested, e.Max)
+}
+
+// needStat tells whether checking a bolus requires the pump's
+// current state.
+func (l *Limits) needStat(dur time.Duration) bool {
+	return l.MaxDaily != 0 || l.MaxIOB != 0 || (dur != 0 && l.MaxRate != 0)
+}
+
+// CheckRate checks a target effective rate against the limits.
+func (l *Limits) CheckRate(rate Rate) error {
+	if l.MaxRate != 0 && rate > l.MaxRate {
+		return &LimitError{"effective rate", rate, l.MaxRate}
+	}
+	return nil
+}
+
+// Check checks a bolus, extended over dur if nonzero, against the
+// limits. The pump state stat may be nil only if no limit requires it.
+func (l *Limits) Check(stat *Stat, bolus Amount, dur time.Duration) error {
+	if dur == 0 {
+		if l.MaxBolus != 0 && bolus > l.MaxBolus {
+			return &LimitError{"bolus", bolus, l.MaxBolus}
+		}
+	} else {
+		if l.MaxCombo != 0 && bolus > l.MaxCombo {
+			return &LimitError{"combo", bolus, l.MaxCombo}
+		}
+		if l.MaxRate != 0 {
+			rate := stat.EffectiveBasal() + Rate(float64(bolus)/dur.Hours())*MilliunitsPerHour
+			if err := l.CheckRate(rate); err != nil {
+				return err
+			}
+		}
+	}
+
+	if l.MaxDaily != 0 && stat.DailyInsulin()+bolus > l.MaxDaily {
+		return &LimitError{"daily insulin", stat.DailyInsulin() + bolus, l.MaxDaily}
+	}
+	if l.MaxIOB != 0 && stat.IOB+bolus > l.MaxIOB {
+		return &LimitError{"IOB", stat.IOB + bolus, l.MaxIOB}
+	}
+
+	return nil
+}
+
+// CheckDual checks a dual-wave bolus against the limits. The extended
+// portion is checked as though the immediate portion had already been
+// delivered.
+func (l *Limits) CheckDual(stat *Stat, immediate, extended Amount, dur time.Duration) error {
+	if err := l.Check(stat, immediate, 0); err != nil {
+		return err
+	}
+
+	after := *stat
+	after.IOB += immediate
+	after.DailyBolus += immediate
+	return l.Check(&after, extended, dur)
+}
+
+// SetLimits sets the safety limits enforced by the pump's delivery calls.
+func (p *Pump) SetLimits(l Limits) {
+	p.limits = l
+}
+
+// Limits returns the safety limits currently in effect.
+func (p *Pump) Limits() Limits {
+	return p.limits
+}
@@ -0,0 +1,70 @@
+package pump
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimits_Check(t *testing.T) {
+	l := &Limits{
+		MaxBolus: 2 * Unit,
+		MaxCombo: 3 * Unit,
+		MaxRate:  2 * UnitsPerHour,
+		MaxDaily: 40 * Unit,
+		MaxIOB:   5 * Unit,
+	}
+	stat := &Stat{
+		Basal:      500 * MilliunitsPerHour,
+		Temp:       100,
+		IOB:        1 * Unit,
+		DailyBasal: 10 * Unit,
+		DailyBolus: 20 * Unit,
+	}
+
+	tests := []struct {
+		bolus Amount
+		dur   time.Duration
+		limit string
+	}{
+		{2 * Unit, 0, ""},
+		{2050 * Milliunit, 0, "bolus"},
+		{1 * Unit, time.Hour, ""},
+		{3500 * Milliunit, 6 * time.Hour, "combo"},
+		{1500 * Milliunit, 30 * time.Minute, "effective rate"},
+	}
+
+	for _, test := range tests {
+		err := l.Check(stat, test.bolus, test.dur)
+		if test.limit == "" {
+			if err != nil {
+				t.Errorf("%s/%s: %s", test.bolus, test.dur, err)
+			}
+			continue
+		}
+		lerr, ok := err.(*LimitError)
+		if !ok || lerr.Limit != test.limit {
+			t.Errorf("%s/%s: expected %s limit, got %v", test.bolus, test.dur, test.limit, err)
+		}
+	}
+
+	stat.DailyBolus = 29 * Unit
+	if err, ok := l.Check(stat, 1500*Milliunit, 0).(*LimitError); !ok || err.Limit != "daily insulin" {
+		t.Errorf("expected daily insulin limit, got %v", err)
+	}
+
+	stat.DailyBolus = 0
+	stat.IOB = 4 * Unit
+	if err, ok := l.Check(stat, 1500*Milliunit, 0).(*LimitError); !ok || err.Limit != "IOB" {
+		t.Errorf("expected IOB limit, got %v", err)
+	}
+}
+
+func TestLimits_zero(t *testing.T) {
+	l := new(Limits)
+	if l.needStat(time.Hour) {
+		t.Error("zero limits should not need stat")
+	}
+	if err := l.Check(nil, 100*Unit, time.Hour); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,7 @@
 package pump
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"time"
@@ -41,6 +42,23 @@ func (r Rate) String() string {
 	return fmt.Sprintf("%.3fU/hr", float64(r)/1000)
 }
 
+// A Clock determines how timestamps in pump messages are decoded.
+// Messages that carry timestamps embed a Clock, which Pump.Call sets
+// before unmarshaling a reply.
+type Clock struct {
+	Ref time.Time      // Reference from which years are inferred; the host clock if zero
+	Loc *time.Location // The pump's time zone; time.Local if nil
+}
+
+func (c *Clock) setClock(c1 Clock) {
+	*c = c1
+}
+
+// Implemented by replies that embed a Clock.
+type clocked interface {
+	setClock(Clock)
+}
+
 type Arg interface {
 	Marshal() []byte
 }
@@ -89,6 +107,8 @@ func (k *Keepalive) Unmarshal(b []byte) error {
 // This seems exactly tailored to render the remote/pump
 // home screen.
 type Status struct {
+	Clock
+
 	Warn bool // true when a warning is active
 
 	Now time.Time // Current pump time
@@ -125,7 +145,7 @@ func (s *Status) Unmarshal(b []byte) (err error) {
 	b = b[3:]
 
 	// Current time
-	s.Now, b = gtime(b)
+	s.Now, b = gtime(b, s.Clock)
 
 	// 4 unknown
 	b = b[4:]
@@ -159,7 +179,35 @@ func (s *Status) Unmarshal(b []byte) (err error) {
 
 }
 
+// Status1 is issued by the remote, but its fields have not yet been
+// mapped. Its body is kept raw so that it can be compared across
+// pump states.
+type Status1 struct {
+	Raw []byte
+}
+
+func (s *Status1) String() string {
+	return fmt.Sprintf("Status1 [%d] %x", len(s.Raw), s.Raw)
+}
+
+// Dump formats the raw body one byte per line, with its offset, in
+// hex, decimal and binary.
+func (s *Status1) Dump() string {
+	var b bytes.Buffer
+	for i, u8 := range s.Raw {
+		fmt.Fprintf(&b, "%02d  %02x  %3d  %08b\n", i, u8, u8, u8)
+	}
+	return b.String()
+}
+
+func (s *Status1) Unmarshal(b []byte) error {
+	s.Raw = append([]byte(nil), b...)
+	return nil
+}
+
 type Status2 struct {
+	Clock
+
 	BolusTime  time.Time
 	Bolus, IOB Amount
 }
@@ -179,7 +227,7 @@ func (s *Status2) Unmarshal(b []byte) (err error) {
 	b = b[4:]
 	u16, b := gbit16(b)
 	s.Bolus = Amount(u16) * Milliunit
-	s.BolusTime, b = gtime(b)
+	s.BolusTime, b = gtime(b, s.Clock)
 
 	b = b[6:]
 	u16, b = gbit16(b)
@@ -220,6 +268,8 @@ func (s *Status3) Unmarshal(b []byte) (err error) {
 }
 
 type Status4 struct {
+	Clock
+
 	Active     bool
 	Start, End time.Time
 
@@ -244,7 +294,7 @@ func (s *Status4) Unmarshal(b []byte) (err error) {
 
 	u8, b := gbit8(b)
 	s.Active = u8&0x1 == 0x1
-	s.Start, b = gtime(b)
+	s.Start, b = gtime(b, s.Clock)
 
 	hh, b := gbit8(b)
 	mm, b := gbit8(b)
@@ -364,4 +414,4 @@ func (d *Deliverystatus) Unmarshal(b []byte) (err error) {
 	}
 
 	return
-}
\ No newline at end of file
+}
@@ -14,10 +14,15 @@ func mustDecode(s string) []byte {
 	return b
 }
 
+// The time at which the captures were taken. Decoding them with this
+// reference keeps the inferred year independent of the current date.
+var captureRef = time.Date(2016, 6, 5, 0, 0, 0, 0, time.Local)
+
 var status_NoTemp = mustDecode("0103000059040e0300000000fa0008000000000000000400")
 
 func TestStatus_NoTemp(t *testing.T) {
 	s := new(Status)
+	s.Ref = captureRef
 	if err := s.Unmarshal(status_NoTemp); err != nil {
 		t.Error(err)
 	}
@@ -37,12 +42,17 @@ func TestStatus_NoTemp(t *testing.T) {
 	if s.Temp != 0 {
 		t.Error("bad temp")
 	}
+
+	if s.Warn {
+		t.Error("bad warning")
+	}
 }
 
 var status = mustDecode("0103000059050f0a00000000fa0008000001baff040c041e")
 
 func TestStatus(t *testing.T) {
 	s := new(Status)
+	s.Ref = captureRef
 	if err := s.Unmarshal(status); err != nil {
 		t.Error(err)
 	}
@@ -76,6 +86,7 @@ var status_temp = mustDecode("0103000059050f1700000000fa000800000128000018001e")
 
 func TestStatus_temp(t *testing.T) {
 	s := new(Status)
+	s.Ref = captureRef
 	if err := s.Unmarshal(status_temp); err != nil {
 		t.Error(err)
 	}
@@ -117,6 +128,7 @@ var status2 = mustDecode("01290100fa0059051209a0860100b88818003b0400000700")
 
 func TestStatus2(t *testing.T) {
 	s := new(Status2)
+	s.Ref = captureRef
 	err := s.Unmarshal(status2)
 	if err != nil {
 		t.Error(err)
@@ -165,6 +177,7 @@ var status4 = mustDecode("0101590516210221c800e80300000400")
 
 func TestStatus4(t *testing.T) {
 	s := new(Status4)
+	s.Ref = captureRef
 	if err := s.Unmarshal(status4); err != nil {
 		t.Error(err)
 	}
@@ -193,6 +206,7 @@ var status4_cancelled = mustDecode("0102590516211625d200e80300000000")
 
 func TestStatus4_cancelled(t *testing.T) {
 	s := new(Status4)
+	s.Ref = captureRef
 	if err := s.Unmarshal(status4_cancelled); err != nil {
 		t.Error(err)
 	}
@@ -216,3 +230,149 @@ func TestStatus4_cancelled(t *testing.T) {
 		t.Error("bad total")
 	}
 }
+
+func TestGtime(t *testing.T) {
+	tests := []struct {
+		yearmonth uint8
+		ref       time.Time
+		expected  int
+	}{
+		{0x59, time.Date(2016, 6, 5, 0, 0, 0, 0, time.Local), 2016},
+		{0x59, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2016},
+		{0x5f, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2022},
+		{0x50, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2023},
+		{0x5b, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2018},
+		{0x53, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2026},
+		{0x54, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2027},
+		{0x55, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), 2012},
+
+		// Rollover of the four-bit year, from 2022 to 2023.
+		{0xbf, time.Date(2022, 12, 31, 23, 59, 0, 0, time.Local), 2022},
+		{0x00, time.Date(2022, 12, 31, 23, 59, 0, 0, time.Local), 2023},
+		{0xbf, time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local), 2022},
+		{0x00, time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local), 2023},
+
+		// And again, from 2038 to 2039.
+		{0xbf, time.Date(2039, 1, 1, 0, 0, 0, 0, time.Local), 2038},
+		{0x00, time.Date(2039, 1, 1, 0, 0, 0, 0, time.Local), 2039},
+	}
+
+	for _, test := range tests {
+		b := []byte{test.yearmonth, 5, 18, 9}
+		tm, _ := gtime(b, Clock{Ref: test.ref})
+		if tm.Year() != test.expected {
+			t.Errorf("%02x ref %s: expected %d, got %d", test.yearmonth, test.ref, test.expected, tm.Year())
+		}
+		if tm.Month() != time.Month(1+test.yearmonth>>4) {
+			t.Errorf("%02x: bad month %s", test.yearmonth, tm.Month())
+		}
+	}
+}
+
+func TestPtime(t *testing.T) {
+	ref := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	for _, tm := range []time.Time{
+		time.Date(2016, 6, 5, 18, 9, 0, 0, time.Local),
+		time.Date(2022, 12, 31, 23, 59, 0, 0, time.Local),
+		time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2026, 10, 18, 7, 30, 0, 0, time.Local),
+	} {
+		b := ptime(nil, tm, Clock{})
+		tm1, _ := gtime(b, Clock{Ref: ref})
+		if !tm.Equal(tm1) {
+			t.Errorf("%x: expected %s, got %s", b, tm, tm1)
+		}
+	}
+
+	if b := ptime(nil, time.Date(2016, 6, 5, 18, 9, 0, 0, time.Local), Clock{}); hex.EncodeToString(b) != "59051209" {
+		t.Errorf("bad encoding %x", b)
+	}
+}
+
+func TestStatus2_ref(t *testing.T) {
+	s := new(Status2)
+	s.Ref = time.Date(2040, 1, 1, 0, 0, 0, 0, time.Local)
+	if err := s.Unmarshal(status2); err != nil {
+		t.Error(err)
+	}
+
+	if !time.Date(2032, 6, 5, 18, 9, 0, 0, time.Local).Equal(s.BolusTime) {
+		t.Errorf("bad time %s", s.BolusTime)
+	}
+}
+
+func TestGtime_loc(t *testing.T) {
+	loc := time.FixedZone("pump", -7*60*60)
+	c := Clock{Ref: time.Date(2016, 6, 5, 0, 0, 0, 0, time.UTC), Loc: loc}
+
+	tm, _ := gtime([]byte{0x59, 0x05, 0x12, 0x09}, c)
+	if !time.Date(2016, 6, 6, 1, 9, 0, 0, time.UTC).Equal(tm) {
+		t.Errorf("bad time %s", tm)
+	}
+
+	if b := ptime(nil, tm.UTC(), c); hex.EncodeToString(b) != "59051209" {
+		t.Errorf("bad encoding %x", b)
+	}
+}
+
+// Not a capture: Status1 is not yet mapped, so this only exercises
+// the raw dump.
+var status1 = mustDecode("01a503")
+
+func TestStatus1(t *testing.T) {
+	b := append([]byte(nil), status1...)
+	s := new(Status1)
+	if err := s.Unmarshal(b); err != nil {
+		t.Error(err)
+	}
+
+	b[0] = 0xff
+	if s.Raw[0] != 0x01 {
+		t.Error("raw body aliases the frame")
+	}
+
+	expected := "00  01    1  00000001\n01  a5  165  10100101\n02  03    3  00000011\n"
+	if s.Dump() != expected {
+		t.Errorf("bad dump %q", s.Dump())
+	}
+}
+
+func TestBolus_Marshal(t *testing.T) {
+	tests := []struct {
+		bolus    Bolus
+		expected string
+	}{
+		{Bolus{Bolus: 250 * Milliunit}, "0000fa0005ff00"},
+		{Bolus{Bolus: 1 * Unit, Duration: 4 * time.Hour}, "0100e80317fc28"},
+	}
+
+	for _, test := range tests {
+		b := test.bolus.Marshal()
+		if len(b) != 28 {
+			t.Errorf("%s: bad length %d", &test.bolus, len(b))
+		}
+		if hex.EncodeToString(b[:7]) != test.expected {
+			t.Errorf("%s: expected %s, got %x", &test.bolus, test.expected, b[:7])
+		}
+		for _, u8 := range b[7:] {
+			if u8 != 0 {
+				t.Errorf("%s: nonzero padding %x", &test.bolus, b)
+				break
+			}
+		}
+	}
+}
+
+var status_warn = mustDecode("1103000059040e0300000000fa0008000000000000000400")
+
+func TestStatus_warn(t *testing.T) {
+	s := new(Status)
+	s.Ref = captureRef
+	if err := s.Unmarshal(status_warn); err != nil {
+		t.Error(err)
+	}
+
+	if !s.Warn {
+		t.Error("bad warning")
+	}
+}
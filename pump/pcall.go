@@ -144,6 +144,16 @@ func (f *frame) String() string {
 type Pump struct {
 	radio  *radio.Radio
 	tagidx uint8
+
+	limits    Limits
+	dryrun    bool
+	clearWarn bool
+
+	timeRef func() time.Time
+	last    time.Time
+	loc     *time.Location
+
+	driftThreshold time.Duration
 }
 
 var tagSeq = []byte{
@@ -152,8 +162,10 @@ var tagSeq = []byte{
 	0xb6,
 }
 
+const defaultDriftThreshold = 5 * time.Minute
+
 func New(radio *radio.Radio) *Pump {
-	return &Pump{radio: radio}
+	return &Pump{radio: radio, driftThreshold: defaultDriftThreshold}
 }
 
 func Dial(device, addr string) (*Pump, error) {
@@ -164,6 +176,85 @@ func Dial(device, addr string) (*Pump, error) {
 	return New(r), nil
 }
 
+// SetTimeRef sets the function that supplies the reference time from
+// which the years of pump timestamps are inferred. When ref is nil, or
+// returns the zero time, the host clock is used. To infer years from
+// the last known pump time instead, use
+//
+//	p.SetTimeRef(p.LastTime)
+func (p *Pump) SetTimeRef(ref func() time.Time) {
+	p.timeRef = ref
+}
+
+// LastTime returns the pump time last reported by a Status call, or
+// the zero time if there has been none.
+func (p *Pump) LastTime() time.Time {
+	return p.last
+}
+
+// SetLocation sets the time zone of the pump's wall clock. When loc
+// is nil, the pump is assumed to keep local time.
+func (p *Pump) SetLocation(loc *time.Location) {
+	p.loc = loc
+}
+
+// Location returns the time zone of the pump's wall clock.
+func (p *Pump) Location() *time.Location {
+	if p.loc == nil {
+		return time.Local
+	}
+	return p.loc
+}
+
+// SetDriftThreshold sets how far the pump's clock may drift from the
+// host's before Stat reports it.
+func (p *Pump) SetDriftThreshold(d time.Duration) {
+	p.driftThreshold = d
+}
+
+// clock returns the Clock with which to decode replies.
+func (p *Pump) clock() Clock {
+	c := Clock{Loc: p.loc}
+	if p.timeRef != nil {
+		c.Ref = p.timeRef()
+	}
+	return c
+}
+
+// SetDryrun sets whether the pump is in dry-run mode. In dry-run
+// mode, calls that affect insulin delivery are logged, but never
+// transmitted; all other calls go to the pump as usual.
+func (p *Pump) SetDryrun(dryrun bool) {
+	p.dryrun = dryrun
+}
+
+// SetClearWarn sets whether SetRate may clear an active warning on
+// its own. Otherwise, SetRate refuses with ErrWarning while a warning
+// is active.
+func (p *Pump) SetClearWarn(clear bool) {
+	p.clearWarn = clear
+}
+
+// Dryrun tells whether the pump is in dry-run mode.
+func (p *Pump) Dryrun() bool {
+	return p.dryrun
+}
+
+// isDelivery tells whether calls of the given type affect insulin
+// delivery.
+func isDelivery(typ uint8) bool {
+	switch typ {
+	case CallBolus, CallBolusack, CallComboack, CallCancelcombo, CallClearwarn:
+		return true
+	}
+	return false
+}
+
+// Radio returns the radio with which the pump is reached.
+func (p *Pump) Radio() *radio.Radio {
+	return p.radio
+}
+
 func (p *Pump) nextTag() (uint8, error) {
 	if int(p.tagidx) >= len(tagSeq) {
 		return 0, errors.New("ran out of tags")
@@ -213,6 +304,10 @@ func (p *Pump) Call(typ uint8, arg Arg, reply Reply) error {
 		tx.Body = arg.Marshal()
 	}
 
+	if p.dryrun && isDelivery(typ) {
+		return p.logDelivery(tx, arg, reply)
+	}
+
 	if typ == CallAdjourn {
 		return p.tx(tx)
 	}
@@ -251,11 +346,47 @@ func (p *Pump) Call(typ uint8, arg Arg, reply Reply) error {
 		return errors.New("unexpected reply type")
 	}
 
-	if reply != nil {
-		return reply.Unmarshal(rx.Body)
-	} else {
+	if reply == nil {
 		return nil
 	}
+
+	if c, ok := reply.(clocked); ok {
+		c.setClock(p.clock())
+	}
+
+	if err := reply.Unmarshal(rx.Body); err != nil {
+		return err
+	}
+
+	if s, ok := reply.(*Status); ok {
+		p.last = s.Now
+	}
+
+	return nil
+}
+
+// Log a delivery call in place of transmitting it, and fake the
+// pump's reply where the caller depends on it.
+func (p *Pump) logDelivery(tx *frame, arg Arg, reply Reply) error {
+	// Marshal with the tag the call would have used, but don't
+	// consume it: the pump expects the tag sequence to continue.
+	if int(p.tagidx) < len(tagSeq) {
+		tx.Tag = tagSeq[p.tagidx]
+	}
+
+	pkt, err := tx.Marshal()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("dryrun %s: %x", tx, pkt)
+
+	// The pump confirms a bolus by echoing it.
+	if b, ok := reply.(*Bolus); ok {
+		*b = *arg.(*Bolus)
+	}
+
+	return nil
 }
 
 // Transmit and receive a frame with the given radio parameters.
@@ -348,4 +479,4 @@ func (p *Pump) tx(f *frame) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
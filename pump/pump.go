@@ -8,37 +8,55 @@ import (
 	"time"
 )
 
+// Stat is a snapshot of the pump's state. Its JSON encoding is
+// stable: times are RFC 3339, amounts are in milliunits, and rates in
+// milliunits per hour. Times that do not apply, such as the bounds of
+// a temp when none is set, are the zero time, 0001-01-01T00:00:00Z.
 type Stat struct {
-	Now time.Time
+	Now time.Time `json:"now"`
 
-	Basal     Rate
-	Reservoir Amount
+	// The offset of the pump's clock from the host's, and whether
+	// it exceeds the pump's drift threshold.
+	Drift     time.Duration `json:"drift_ns"`
+	DriftWarn bool          `json:"drift_warn"`
 
-	IOB, LastBolus Amount
+	Basal     Rate   `json:"basal"`
+	Reservoir Amount `json:"reservoir"`
 
-	Temp               int
-	TempBegin, TempEnd time.Time
+	IOB           Amount    `json:"iob"`
+	LastBolus     Amount    `json:"last_bolus"`
+	LastBolusTime time.Time `json:"last_bolus_time"`
 
-	ComboActive                bool
-	ComboBegin, ComboEnd       time.Time
-	ComboDelivered, ComboTotal Amount
+	Temp      int       `json:"temp"`
+	TempBegin time.Time `json:"temp_begin"`
+	TempEnd   time.Time `json:"temp_end"`
 
-	DailyBasal, DailyBolus Amount
+	ComboActive    bool      `json:"combo_active"`
+	ComboBegin     time.Time `json:"combo_begin"`
+	ComboEnd       time.Time `json:"combo_end"`
+	ComboDelivered Amount    `json:"combo_delivered"`
+	ComboTotal     Amount    `json:"combo_total"`
 
-	Warn bool
+	DailyBasal Amount `json:"daily_basal"`
+	DailyBolus Amount `json:"daily_bolus"`
+
+	Suspended bool `json:"suspended"`
+
+	Warn bool `json:"warn"`
 }
 
 func (s *Stat) String() string {
 	parts := []string{
-		fmt.Sprintf("%s warn %v basal %s", s.Now.Format(time.Kitchen), s.Warn, s.Basal),
-		fmt.Sprintf("reservoir %s IOB %s lastbolus %s", s.Reservoir, s.IOB, s.LastBolus),
+		fmt.Sprintf("%s drift %s warn %v basal %s", s.Now.Format(time.Kitchen), s.Drift, s.Warn, s.Basal),
+		fmt.Sprintf("reservoir %s IOB %s lastbolus %s %s", s.Reservoir, s.IOB,
+			s.LastBolus, s.LastBolusTime.Format(time.Kitchen)),
 		fmt.Sprintf("temp %d %s-%s", s.Temp,
 			s.TempBegin.Format(time.Kitchen),
 			s.TempEnd.Format(time.Kitchen)),
 		fmt.Sprintf("combo %v %s-%s %s/%s",
 			s.ComboActive, s.ComboBegin.Format(time.Kitchen),
 			s.ComboEnd.Format(time.Kitchen), s.ComboDelivered, s.ComboTotal),
-		fmt.Sprintf("basal %s bolus %s", s.DailyBasal, s.DailyBolus),
+		fmt.Sprintf("basal %s bolus %s suspended %v", s.DailyBasal, s.DailyBolus, s.Suspended),
 	}
 
 	return strings.Join(parts, " ")
@@ -48,6 +66,37 @@ func (s *Stat) DailyInsulin() Amount {
 	return s.DailyBasal + s.DailyBolus
 }
 
+// ReservoirLeft estimates how long the reservoir will last, at the
+// greater of the effective basal rate (plus the rate of any active
+// combo) and today's average rate of use. It returns zero if no
+// insulin is being used.
+func (s *Stat) ReservoirLeft() time.Duration {
+	rate := s.EffectiveBasal()
+	if dur := s.ComboEnd.Sub(s.ComboBegin); s.ComboActive && dur > 0 {
+		rate += Rate(float64(s.ComboTotal)/dur.Hours()) * MilliunitsPerHour
+	}
+
+	y, m, d := s.Now.Date()
+	elapsed := s.Now.Sub(time.Date(y, m, d, 0, 0, 0, 0, s.Now.Location()))
+	if elapsed >= time.Hour {
+		if today := Rate(float64(s.DailyInsulin()) / elapsed.Hours()); today > rate {
+			rate = today
+		}
+	}
+
+	if rate <= 0 {
+		return 0
+	}
+	hours := float64(s.Reservoir) / float64(rate)
+	return time.Duration(hours * float64(time.Hour)).Truncate(time.Minute)
+}
+
+// EffectiveBasal is the basal rate, scaled by any active temp.
+func (s *Stat) EffectiveBasal() Rate {
+	scale := (100.0 + float64(s.Temp)) / 100.0
+	return Rate(float64(s.Basal) * scale)
+}
+
 // Carefully constructed to mimic what the remote would do, roughly.
 func (p *Pump) Stat() (*Stat, error) {
 	var s = new(Stat)
@@ -61,6 +110,9 @@ func (p *Pump) Stat() (*Stat, error) {
 		return nil, err
 	}
 	s.Now = status.Now
+	// The pump's clock has a resolution of one minute.
+	s.Drift = s.Now.Sub(time.Now().Truncate(time.Minute))
+	s.DriftWarn = s.Drift > p.driftThreshold || s.Drift < -p.driftThreshold
 	s.Reservoir = status.Reservoir
 	s.Basal = status.Basal
 	s.Temp = int(status.Temp)
@@ -89,6 +141,7 @@ func (p *Pump) Stat() (*Stat, error) {
 		return nil, err
 	}
 	s.LastBolus = status2.Bolus
+	s.LastBolusTime = status2.BolusTime
 	s.IOB = status2.IOB
 
 	if err := p.Reset(); err != nil {
@@ -107,12 +160,27 @@ func (p *Pump) Stat() (*Stat, error) {
 	}
 	s.DailyBasal = status3.DailyBasal
 	s.DailyBolus = status3.DailyBolus
+	s.Suspended = status3.Suspend
 
 	p.Adjourn()
 
 	return s, nil
 }
 
+// Status1 issues the (as yet unmapped) Status1 call.
+func (p *Pump) Status1() (*Status1, error) {
+	if err := p.Resume(); err != nil {
+		return nil, err
+	}
+	defer p.Adjourn()
+
+	var s Status1
+	if err := p.Call(CallStatus1, nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
 func (p *Pump) CancelCombo() error {
 	if err := p.Resume(); err != nil {
 		return err
@@ -121,74 +189,201 @@ func (p *Pump) CancelCombo() error {
 	return p.Call(CallCancelcombo, nil, nil)
 }
 
+// ErrWarning is returned by SetRate when a warning is active, and it
+// may not clear it.
+var ErrWarning = errors.New("a warning is active on the pump")
+
+// ClearWarn clears the active warning. It verifies through Status
+// that the warning is gone, and through Status4 that any combo was
+// left as it was.
 func (p *Pump) ClearWarn() error {
+	before, err := p.Stat()
+	if err != nil {
+		return err
+	}
+	if !before.Warn {
+		return nil
+	}
+
 	if err := p.Resume(); err != nil {
 		return err
 	}
+	err = p.Call(CallClearwarn, &Clearwarn{}, nil)
+	p.Adjourn()
+	if err != nil {
+		return err
+	}
 
-	defer p.Adjourn()
-	return p.Call(CallCancelcombo, &Clearwarn{}, nil)
+	if p.dryrun {
+		return nil
+	}
+
+	after, err := p.Stat()
+	if err != nil {
+		return err
+	}
+	if after.Warn {
+		return errors.New("warning still active after clearing")
+	}
+	if after.ComboActive != before.ComboActive ||
+		!after.ComboBegin.Equal(before.ComboBegin) ||
+		after.ComboTotal != before.ComboTotal {
+		return errors.New(fmt.Sprintf("clearing warning changed combo from %v %s/%s to %v %s/%s",
+			before.ComboActive, before.ComboDelivered, before.ComboTotal,
+			after.ComboActive, after.ComboDelivered, after.ComboTotal))
+	}
+
+	return nil
 }
 
+// Bolus delivers a bolus, extended over dur if nonzero, after
+// checking it against the pump's limits.
 func (p *Pump) Bolus(bolus Amount, dur time.Duration) error {
-	if int(dur.Minutes())%6 != 0 {
-		return errors.New("combo duration must be increments of 6 minutes")
+	return p.BolusProgress(bolus, dur, nil, nil)
+}
+
+// BolusProgress is like Bolus, but reports the progress of the bolus
+// to the function progress, if it is not nil. If the channel cancel
+// is closed while an extended bolus is being delivered, the bolus is
+// cancelled and BolusProgress returns ErrBolusCancelled. Normal
+// boluses cannot be cancelled, so cancel must be nil for them.
+func (p *Pump) BolusProgress(bolus Amount, dur time.Duration, progress func(BolusEvent), cancel <-chan struct{}) error {
+	if dur == 0 && cancel != nil {
+		return errors.New("normal boluses cannot be cancelled")
 	}
 
-	if err := p.Resume(); err != nil {
+	var stat *Stat
+	if p.limits.needStat(dur) {
+		var err error
+		if stat, err = p.Stat(); err != nil {
+			return err
+		}
+	}
+	if err := p.limits.Check(stat, bolus, dur); err != nil {
 		return err
 	}
+
+	_, err := p.bolus(bolus, dur, progress, cancel)
+	return err
+}
+
+// bolus transmits a bolus without checking limits. The channel
+// cancel is watched only for extended boluses. bolus reports whether
+// it got as far as acknowledging the bolus: until then, the pump
+// delivers nothing.
+func (p *Pump) bolus(bolus Amount, dur time.Duration, progress func(BolusEvent), cancel <-chan struct{}) (acked bool, err error) {
+	if progress == nil {
+		progress = func(BolusEvent) {}
+	}
+	if dur == 0 {
+		cancel = nil
+	}
+
+	acked, cancelled, err := p.deliver(bolus, dur, progress, cancel)
+	if err != nil || !cancelled {
+		return acked, err
+	}
+
+	// Only extended boluses, as combos, can be cancelled.
+	if err := p.CancelCombo(); err != nil {
+		return true, err
+	}
+
+	progress(BolusEvent{Stage: StageCancelled, Bolus: bolus})
+	return true, ErrBolusCancelled
+}
+
+// deliver transmits a bolus, and follows its delivery. It reports
+// whether the acknowledgement that starts delivery was sent; any
+// failure from then on leaves the bolus possibly delivered.
+func (p *Pump) deliver(bolus Amount, dur time.Duration, progress func(BolusEvent), cancel <-chan struct{}) (acked, cancelled bool, err error) {
+	if err := validateBolus(bolus, dur); err != nil {
+		return false, false, err
+	}
+
+	if err := p.Resume(); err != nil {
+		return false, false, err
+	}
 	defer p.Adjourn()
 
 	arg := &Bolus{Bolus: bolus, Duration: dur}
 	var reply Bolus
 
 	if err := p.Call(CallBolus, arg, &reply); err != nil {
-		return err
+		return false, false, err
 	}
 
 	if *arg != reply {
-		return errors.New(fmt.Sprintf("pump returned mismatched bolus response: %s, expected %s"))
+		return false, false, &mismatchError{sent: *arg, reply: reply}
 	}
+	progress(BolusEvent{Stage: StageAccepted, Bolus: bolus})
 
 	var callack uint8 = CallBolusack
 	if reply.Duration != 0 {
 		callack = CallComboack
 	}
 
+	// From here on, the pump may have been told to deliver.
 	if err := p.Call(callack, nil, nil); err != nil {
-		return err
+		return true, false, err
+	}
+	progress(BolusEvent{Stage: StageAcknowledged, Bolus: bolus})
+
+	// Nothing was delivered, so there is nothing to wait for.
+	if p.dryrun {
+		progress(BolusEvent{Stage: StageDone, Bolus: bolus})
+		return true, false, nil
 	}
 
-Loop:
 	for {
+		select {
+		case <-cancel:
+			return true, true, nil
+		default:
+		}
+
 		var s Deliverystatus
 		if err := p.Call(CallDeliverystatus, nil, &s); err != nil {
-			return err
+			return true, false, err
 		}
 
 		switch s.Status {
 		case BolusBusy, BolusUnknown:
+			progress(BolusEvent{Stage: StageBusy, Bolus: bolus})
 			if err := p.Call(CallDeliverycontinue, nil, nil); err != nil {
-				return err
+				return true, false, err
 			}
 
 		case BolusDone:
-			break Loop
+			progress(BolusEvent{Stage: StageDone, Bolus: bolus})
+			return true, false, nil
 		}
 	}
-
-	return nil
 }
 
 // Convergent.
 func (p *Pump) SetRate(log *log.Logger, rate Rate) (done bool, err error) {
+	if err = p.limits.CheckRate(rate); err != nil {
+		return
+	}
+
 	var stat *Stat
 	if stat, err = p.Stat(); err != nil {
 		return
 	}
 
+	if stat.DriftWarn {
+		log.Printf("pump clock is off by %s; combo timing may be inaccurate", stat.Drift)
+	}
+
+	// Warnings are not typed, so clearing one could dismiss an
+	// occlusion as readily as a low reservoir.
+	if stat.Warn && !p.clearWarn {
+		err = ErrWarning
+		return
+	}
 	if stat.Warn {
+		log.Printf("clearing warning")
 		if err = p.ClearWarn(); err != nil {
 			return
 		}
@@ -198,8 +393,7 @@ func (p *Pump) SetRate(log *log.Logger, rate Rate) (done bool, err error) {
 
 	// Compute marginal rate required to reach the desired rate.
 	var need Rate
-	scale := (100.0 + float64(stat.Temp)) / 100.0
-	base := Rate(float64(stat.Basal) * scale)
+	base := stat.EffectiveBasal()
 	if base > rate {
 		need = 0 * MilliunitsPerHour
 	} else {
@@ -223,8 +417,7 @@ func (p *Pump) SetRate(log *log.Logger, rate Rate) (done bool, err error) {
 		300 * time.Minute,
 	}
 	for i, d := range durations {
-		// The pump accepts 50 milliunit delivery increments.
-		tot := need.Total(d).Truncate(50 * Milliunit)
+		tot := need.Total(d).Truncate(BolusIncrement)
 		proposed := Rate((60/d.Minutes())*float64(tot)) * MilliunitsPerHour
 		diff := rate - proposed
 		if diff < 0 {
@@ -257,12 +450,21 @@ func (p *Pump) SetRate(log *log.Logger, rate Rate) (done bool, err error) {
 		return
 	}
 
+	if err = p.limits.Check(stat, total, dur); err != nil {
+		return
+	}
+
 	// The existing combo isn't sufficient; we have to issue a new combo.
 	if err = p.CancelCombo(); err != nil {
 		return
 	}
 
+	if total == 0*Milliunit {
+		log.Printf("cancelled combo; total is zero")
+		return
+	}
+
 	log.Printf("setting new combo %s/%s", total, dur)
-	err = p.Bolus(total, dur)
+	_, err = p.bolus(total, dur, nil, nil)
 	return
 }
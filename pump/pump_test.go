@@ -0,0 +1,47 @@
+package pump
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStat_ReservoirLeft(t *testing.T) {
+	s := &Stat{
+		Now:       time.Date(2016, 6, 5, 12, 0, 0, 0, time.Local),
+		Basal:     500 * MilliunitsPerHour,
+		Reservoir: 24 * Unit,
+	}
+
+	// At the basal rate.
+	if left := s.ReservoirLeft(); left != 48*time.Hour {
+		t.Errorf("expected 48h, got %s", left)
+	}
+
+	// At today's rate: 12U in 12 hours.
+	s.DailyBasal = 6 * Unit
+	s.DailyBolus = 6 * Unit
+	if left := s.ReservoirLeft(); left != 24*time.Hour {
+		t.Errorf("expected 24h, got %s", left)
+	}
+
+	// Too early in the day to use today's rate.
+	s.Now = time.Date(2016, 6, 5, 0, 30, 0, 0, time.Local)
+	if left := s.ReservoirLeft(); left != 48*time.Hour {
+		t.Errorf("expected 48h, got %s", left)
+	}
+
+	// With a combo of 1U over 2 hours on top of the basal rate.
+	s.ComboActive = true
+	s.ComboBegin = s.Now
+	s.ComboEnd = s.Now.Add(2 * time.Hour)
+	s.ComboTotal = 1 * Unit
+	if left := s.ReservoirLeft(); left != 24*time.Hour {
+		t.Errorf("expected 24h, got %s", left)
+	}
+	s.ComboActive = false
+
+	s.Basal = 0
+	if left := s.ReservoirLeft(); left != 0 {
+		t.Errorf("expected 0, got %s", left)
+	}
+}
@@ -0,0 +1,184 @@
+package pump
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The pump's delivery increments.
+const (
+	BolusIncrement = 50 * Milliunit
+	BasalIncrement = 25 * MilliunitsPerHour
+)
+
+// ParseAmount parses an amount of insulin, such as "1.25U", "250mU",
+// or "1.25". Bare numbers are in units.
+func ParseAmount(s string) (Amount, error) {
+	m, err := parseMilli(s, "U")
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid amount %q: %s", s, err))
+	}
+	return Amount(m) * Milliunit, nil
+}
+
+// ParseRate parses a rate of insulin delivery, such as "0.8U/hr",
+// "800mU/hr", or "0.8". Bare numbers are in units per hour.
+func ParseRate(s string) (Rate, error) {
+	m, err := parseMilli(s, "U/hr", "U/h")
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid rate %q: %s", s, err))
+	}
+	return Rate(m) * MilliunitsPerHour, nil
+}
+
+// parseMilli parses a decimal number, with an optional unit or
+// milli-unit suffix, into thousandths of the unit.
+func parseMilli(s string, units ...string) (int64, error) {
+	s = strings.TrimSpace(s)
+	scale := 1000.0
+
+	for _, unit := range units {
+		if hasSuffixFold(s, "m"+unit) {
+			s, scale = s[:len(s)-len(unit)-1], 1
+			break
+		}
+		if hasSuffixFold(s, unit) {
+			s = s[:len(s)-len(unit)]
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("not a number")
+	}
+
+	m := math.Round(f * scale)
+	switch {
+	case math.Abs(f*scale-m) > 1e-6:
+		return 0, errors.New("finer than a milliunit")
+	case m < 0:
+		return 0, errors.New("negative")
+	case m > math.MaxInt32:
+		return 0, errors.New("out of range")
+	}
+
+	return int64(m), nil
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// ValidateBolus checks that the amount can be delivered as a bolus,
+// in the pump's bolus increments.
+func (a Amount) ValidateBolus() error {
+	if a <= 0 || a%BolusIncrement != 0 {
+		return errors.New(fmt.Sprintf("bolus %s is not a positive multiple of %s", a, BolusIncrement))
+	}
+	if a > maxBolus {
+		return errors.New(fmt.Sprintf("bolus %s exceeds %s", a, maxBolus))
+	}
+	return nil
+}
+
+// ValidateBasal checks that the rate can be programmed as a basal
+// rate, in the pump's basal increments.
+func (r Rate) ValidateBasal() error {
+	if r < 0 || r%BasalIncrement != 0 {
+		return errors.New(fmt.Sprintf("basal rate %s is not a multiple of %s", r, BasalIncrement))
+	}
+	return nil
+}
+
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalText(b []byte) error {
+	a1, err := ParseAmount(string(b))
+	if err != nil {
+		return err
+	}
+	*a = a1
+	return nil
+}
+
+// Amounts are marshaled to JSON as numbers of milliunits. They may be
+// unmarshaled from either milliunits or strings accepted by
+// ParseAmount that carry a unit, such as "2U" or "500mU": a bare
+// number is always milliunits.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(a))
+}
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	m, text, err := unmarshalMilli(b, "U")
+	if err != nil {
+		return err
+	} else if text != nil {
+		return a.UnmarshalText(text)
+	}
+	*a = Amount(m) * Milliunit
+	return nil
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalText(b []byte) error {
+	r1, err := ParseRate(string(b))
+	if err != nil {
+		return err
+	}
+	*r = r1
+	return nil
+}
+
+// Rates are marshaled to JSON as numbers of milliunits per hour. They
+// may be unmarshaled from either milliunits per hour or strings
+// accepted by ParseRate that carry a unit, such as "1.5U/hr".
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(r))
+}
+
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	m, text, err := unmarshalMilli(b, "U/hr", "U/h")
+	if err != nil {
+		return err
+	} else if text != nil {
+		return r.UnmarshalText(text)
+	}
+	*r = Rate(m) * MilliunitsPerHour
+	return nil
+}
+
+// unmarshalMilli unmarshals either a JSON number of milliunits, or a
+// JSON string ending in one of units, which it returns as text. A
+// string without a unit is refused, since it would be read as units
+// where the same number would be read as milliunits.
+func unmarshalMilli(b []byte, units ...string) (m int64, text []byte, err error) {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err = json.Unmarshal(b, &s); err != nil {
+			return
+		}
+		for _, unit := range units {
+			if hasSuffixFold(strings.TrimSpace(s), unit) {
+				return 0, []byte(s), nil
+			}
+		}
+		err = errors.New(fmt.Sprintf("%q has no unit; expected e.g. \"1%s\", or integer milliunits", s, units[0]))
+		return
+	}
+
+	if err = json.Unmarshal(b, &m); err != nil {
+		err = errors.New(fmt.Sprintf("expected integer milliunits or a string, got %s", b))
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package pump
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Amount
+		ok       bool
+	}{
+		{"1.25U", 1250 * Milliunit, true},
+		{"1.25 U", 1250 * Milliunit, true},
+		{"250mU", 250 * Milliunit, true},
+		{"1.25", 1250 * Milliunit, true},
+		{"0.05u", 50 * Milliunit, true},
+		{"1.250U", 1250 * Milliunit, true},
+		{"0.0005U", 0, false},
+		{"-1U", 0, false},
+		{"U", 0, false},
+		{"NaN", 0, false},
+		{"1U/hr", 0, false},
+	}
+
+	for _, test := range tests {
+		a, err := ParseAmount(test.in)
+		if test.ok && (err != nil || a != test.expected) {
+			t.Errorf("%q: expected %s, got %s (%v)", test.in, test.expected, a, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%q: expected error, got %s", test.in, a)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Rate
+		ok       bool
+	}{
+		{"0.8U/hr", 800 * MilliunitsPerHour, true},
+		{"0.8U/h", 800 * MilliunitsPerHour, true},
+		{"800mU/hr", 800 * MilliunitsPerHour, true},
+		{"0.8", 800 * MilliunitsPerHour, true},
+		{"0.800U/hr", 800 * MilliunitsPerHour, true},
+		{"0.8U", 0, false},
+		{"fast", 0, false},
+		{"nanU/hr", 0, false},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRate(test.in)
+		if test.ok && (err != nil || r != test.expected) {
+			t.Errorf("%q: expected %s, got %s (%v)", test.in, test.expected, r, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%q: expected error, got %s", test.in, r)
+		}
+	}
+}
+
+func TestAmount_text(t *testing.T) {
+	for _, a := range []Amount{0, 50 * Milliunit, 1250 * Milliunit, 40 * Unit} {
+		b, err := a.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a1 Amount
+		if err := a1.UnmarshalText(b); err != nil || a1 != a {
+			t.Errorf("%s: round trip gave %s (%v)", a, a1, err)
+		}
+	}
+
+	for _, r := range []Rate{0, 25 * MilliunitsPerHour, 800 * MilliunitsPerHour} {
+		b, err := r.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r1 Rate
+		if err := r1.UnmarshalText(b); err != nil || r1 != r {
+			t.Errorf("%s: round trip gave %s (%v)", r, r1, err)
+		}
+	}
+}
+
+func TestLimits_JSON(t *testing.T) {
+	var l Limits
+	in := `{"max_bolus": "2U", "max_combo": 3000, "max_rate": "1.5U/hr", "max_iob": "500mU"}`
+	if err := json.Unmarshal([]byte(in), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Limits{
+		MaxBolus: 2 * Unit,
+		MaxCombo: 3 * Unit,
+		MaxRate:  1500 * MilliunitsPerHour,
+		MaxIOB:   500 * Milliunit,
+	}
+	if l != expected {
+		t.Errorf("expected %+v, got %+v", expected, l)
+	}
+
+	b, err := json.Marshal(&l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"max_bolus":2000,"max_combo":3000,"max_rate":1500,"max_daily":0,"max_iob":500}` {
+		t.Errorf("bad marshal %s", b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"max_bolus": 2.5}`), &l); err == nil {
+		t.Error("expected error for fractional milliunits")
+	}
+	if err := json.Unmarshal([]byte(`{"max_bolus": "1000"}`), &l); err == nil {
+		t.Error("expected error for string without a unit")
+	}
+	if err := json.Unmarshal([]byte(`{"max_rate": "1.5U"}`), &l); err == nil {
+		t.Error("expected error for rate without a unit")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (1250 * Milliunit).ValidateBolus(); err != nil {
+		t.Error(err)
+	}
+	if err := (1260 * Milliunit).ValidateBolus(); err == nil {
+		t.Error("expected error for bolus off increment")
+	}
+	if err := (0 * Milliunit).ValidateBolus(); err == nil {
+		t.Error("expected error for zero bolus")
+	}
+	if err := (65550 * Milliunit).ValidateBolus(); err == nil {
+		t.Error("expected error for bolus beyond 16 bits")
+	}
+	if err := (825 * MilliunitsPerHour).ValidateBasal(); err != nil {
+		t.Error(err)
+	}
+	if err := (810 * MilliunitsPerHour).ValidateBasal(); err == nil {
+		t.Error("expected error for rate off increment")
+	}
+}
@@ -19,6 +19,21 @@ func (err RadioError) Error() string {
 type Radio struct {
 	rw io.ReadWriter
 	// Reset() err
+
+	stats Stats
+}
+
+// Stats counts the calls made on a radio.
+type Stats struct {
+	Calls    int `json:"calls"`
+	Errors   int `json:"errors"`   // Calls that failed to complete
+	Rerrs    int `json:"rerrs"`    // Calls answered with Rerr
+	Timeouts int `json:"timeouts"` // Rerrs that were timeouts
+}
+
+// Stats returns the counts of calls made on the radio so far.
+func (r *Radio) Stats() Stats {
+	return r.stats
 }
 
 var openers = map[string]func(string) (io.ReadWriter, error){
@@ -45,6 +60,25 @@ func New(rw io.ReadWriter) *Radio {
 }
 
 func (r *Radio) Call(req *Call) (*Call, error) {
+	r.stats.Calls++
+
+	rep, err := r.call(req)
+	if err != nil {
+		r.stats.Errors++
+		return nil, err
+	}
+
+	if rep.Type == Rerr {
+		r.stats.Rerrs++
+		if rep.Err == ErrTimeout {
+			r.stats.Timeouts++
+		}
+	}
+
+	return rep, nil
+}
+
+func (r *Radio) call(req *Call) (*Call, error) {
 	if *logradio {
 		log.Printf("radio tx: %s", req)
 	}